@@ -0,0 +1,77 @@
+package sjson
+
+import "fmt"
+
+// Batch is a set of edits to many json documents that are applied together,
+// so that either every edit succeeds or none of the results are returned.
+// A Batch is not safe for concurrent use by multiple goroutines.
+type Batch struct {
+	docs map[string]string
+	ops  []batchOp
+}
+
+type batchOp struct {
+	id, path string
+	value    interface{}
+	raw      []byte // set instead of value for raw json
+}
+
+// NewBatch returns a Batch for the documents, which are keyed by an id. The
+// documents are not modified.
+func NewBatch(docs map[string]string) *Batch {
+	return &Batch{docs: docs}
+}
+
+// Set adds an edit that sets a json value for the path of a document.
+func (b *Batch) Set(id, path string, value interface{}) {
+	b.ops = append(b.ops, batchOp{id: id, path: path, value: value})
+}
+
+// SetRaw adds an edit that sets a raw json value for the path of a
+// document.
+func (b *Batch) SetRaw(id, path, value string) {
+	b.ops = append(b.ops, batchOp{id: id, path: path,
+		raw: append([]byte{}, value...)})
+}
+
+// Delete adds an edit that deletes the path of a document.
+func (b *Batch) Delete(id, path string) {
+	b.ops = append(b.ops, batchOp{id: id, path: path, value: dtype{}})
+}
+
+// Commit applies the edits, in order, and returns every document of the
+// batch with its edits applied. When an edit fails, such as for an id that
+// is not in the batch, no results are returned along with an error
+// identifying the index of the edit.
+func (b *Batch) Commit() (map[string]string, error) {
+	eds := make(map[string]*Editor)
+	for i, op := range b.ops {
+		ed, ok := eds[op.id]
+		if !ok {
+			json, ok := b.docs[op.id]
+			if !ok {
+				return nil, fmt.Errorf("operation %d: %w", i,
+					&errorType{"unknown document '" + op.id + "'"})
+			}
+			ed = NewEditor([]byte(json))
+			eds[op.id] = ed
+		}
+		var err error
+		if op.raw != nil {
+			err = ed.SetRaw(op.path, op.raw)
+		} else {
+			err = ed.Set(op.path, op.value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	res := make(map[string]string, len(b.docs))
+	for id, json := range b.docs {
+		if ed, ok := eds[id]; ok {
+			json = string(ed.Bytes())
+		}
+		res[id] = json
+	}
+	return res, nil
+}
@@ -0,0 +1,57 @@
+package sjson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	docs := map[string]string{
+		"user":  `{"name":"Tom","age":37}`,
+		"order": `{"items":[1,2]}`,
+		"other": `{"x":1}`,
+	}
+	b := NewBatch(docs)
+	b.Set("user", "age", 38)
+	b.SetRaw("order", "items.-1", `{"id":3}`)
+	b.Delete("user", "name")
+	b.Set("order", "total", 9.5)
+	res, err := b.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"user":  `{"age":38}`,
+		"order": `{"items":[1,2,{"id":3}],"total":9.5}`,
+		"other": `{"x":1}`,
+	}
+	if len(res) != len(expect) {
+		t.Fatalf("expected %d results, got %d", len(expect), len(res))
+	}
+	for id, json := range expect {
+		if res[id] != json {
+			t.Fatalf("%s: expected '%v', got '%v'", id, json, res[id])
+		}
+	}
+	if docs["user"] != `{"name":"Tom","age":37}` {
+		t.Fatalf("document was modified: '%v'", docs["user"])
+	}
+
+	// a failed edit returns no results
+	b = NewBatch(docs)
+	b.Set("user", "age", 38)
+	b.Set("order", "", 1)
+	res, err = b.Commit()
+	if !errors.Is(err, ErrEmptyPath) || res != nil {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+	if !strings.HasPrefix(err.Error(), "operation 1: ") {
+		t.Fatalf("unexpected '%v'", err)
+	}
+	b = NewBatch(docs)
+	b.Set("missing", "a", 1)
+	if res, err = b.Commit(); err == nil || res != nil {
+		t.Fatal("expected an error")
+	}
+}
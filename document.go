@@ -0,0 +1,47 @@
+package sjson
+
+import "sync"
+
+// Document holds a json document that may be read and modified by many
+// goroutines. Every modification is made with Apply, which serializes the
+// modifications and increments the version of the document.
+type Document struct {
+	mu      sync.RWMutex
+	json    string
+	version int64
+}
+
+// NewDocument returns a document holding the json, at version zero.
+func NewDocument(json string) *Document {
+	return &Document{json: json}
+}
+
+// Snapshot returns the current json and its version.
+func (d *Document) Snapshot() (json string, version int64) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.json, d.version
+}
+
+// String returns the current json.
+func (d *Document) String() string {
+	json, _ := d.Snapshot()
+	return json
+}
+
+// Apply calls fn with the current json and replaces it with the returned
+// json, such as the result of Set or Delete, returning the new version. Only
+// one fn is called at a time. When fn returns an error the document and its
+// version are left unchanged.
+func (d *Document) Apply(fn func(cur string) (string, error)) (
+	newVersion int64, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	json, err := fn(d.json)
+	if err != nil {
+		return d.version, err
+	}
+	d.json = json
+	d.version++
+	return d.version, nil
+}
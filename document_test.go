@@ -0,0 +1,46 @@
+package sjson
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestDocument(t *testing.T) {
+	doc := NewDocument(`{"count":0}`)
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := doc.Apply(func(cur string) (string, error) {
+				return Add(cur, "count", 1)
+			}); err != nil {
+				t.Error(err)
+			}
+			doc.Snapshot()
+		}()
+	}
+	wg.Wait()
+	json, version := doc.Snapshot()
+	if version != 50 || gjson.Get(json, "count").Int() != 50 {
+		t.Fatalf("unexpected '%v' at version %d", json, version)
+	}
+	errFail := errors.New("fail")
+	version, err := doc.Apply(func(cur string) (string, error) {
+		return `{}`, errFail
+	})
+	if err != errFail || version != 50 || doc.String() != json {
+		t.Fatalf("unexpected '%v' at version %d (%v)", doc.String(), version,
+			err)
+	}
+	version, err = doc.Apply(func(cur string) (string, error) {
+		return Delete(cur, "count")
+	})
+	if err != nil || version != 51 || doc.String() != `{}` {
+		t.Fatalf("unexpected '%v' at version %d (%v)", doc.String(), version,
+			err)
+	}
+}
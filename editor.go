@@ -0,0 +1,71 @@
+package sjson
+
+import "unsafe"
+
+// Editor applies many edits to one json document while reusing its bytes.
+// When an edit fits in the capacity of the current buffer, it's made in
+// place, otherwise the document is copied to a second buffer which is then
+// reused by the following edits.
+//
+// The Editor takes ownership of the json passed to NewEditor, which may be
+// mutated, and the slice returned by Bytes is only valid until the next
+// edit. Values passed to the Editor must not reference those bytes.
+// An Editor is not safe for concurrent use by multiple goroutines.
+type Editor struct {
+	buf   []byte // the current document
+	spare []byte // reused when an edit does not fit in buf
+	opts  *Options
+}
+
+// NewEditor returns an Editor for the json.
+func NewEditor(json []byte) *Editor {
+	return &Editor{buf: json}
+}
+
+// Bytes returns the current json document.
+func (ed *Editor) Bytes() []byte {
+	return ed.buf
+}
+
+// Set sets a json value for the specified path.
+func (ed *Editor) Set(path string, value interface{}) error {
+	raw, stringify, del, err := valueRaw(value, ed.opts)
+	if err != nil {
+		return &PathError{Path: path, Offset: -1, Err: err}
+	}
+	return ed.apply(path, raw, stringify, del)
+}
+
+// SetRaw sets a raw json value for the specified path.
+func (ed *Editor) SetRaw(path string, value []byte) error {
+	return ed.apply(path, *(*string)(unsafe.Pointer(&value)), false, false)
+}
+
+// Delete deletes a value for the specified path.
+func (ed *Editor) Delete(path string) error {
+	return ed.apply(path, "", false, true)
+}
+
+func (ed *Editor) apply(path, raw string, stringify, del bool) error {
+	jstr := *(*string)(unsafe.Pointer(&ed.buf))
+	e, err := setEdit(jstr, path, raw, stringify, del, ed.opts)
+	if err == errNoChange {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	sz := e.size(jstr)
+	if e.lead == 0 && sz <= cap(ed.buf) {
+		// move the trailing bytes into place, then write the new ones
+		n := len(ed.buf)
+		ed.buf = ed.buf[:cap(ed.buf)]
+		copy(ed.buf[sz-(n-e.end):], ed.buf[e.end:n])
+		e.appendMid(ed.buf[:e.start])
+		ed.buf = ed.buf[:sz]
+		return nil
+	}
+	ed.spare = e.append(ed.spare[:0], jstr)
+	ed.buf, ed.spare = ed.spare, ed.buf
+	return nil
+}
@@ -0,0 +1,89 @@
+package sjson
+
+import (
+	"errors"
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEditor(t *testing.T) {
+	json := `{"name":{"first":"Tom","last":"Anderson"},"age":37,"list":[1,2,3]}`
+	buf := make([]byte, len(json), len(json)+8)
+	copy(buf, json)
+	ed := NewEditor(buf)
+	steps := []struct {
+		op, path, value string
+	}{
+		{"set", "age", "38"},
+		{"set", "name.first", "Janet"},
+		{"set", "name.first", "Jo"},
+		{"raw", "list.-1", `{"a":[true,false]}`},
+		{"del", "list.0", ""},
+		{"del", "missing", ""},
+		{"set", "name.middle", `q"uote`},
+		{"raw", "list.0", `"x"`},
+		{"del", "name", ""},
+	}
+	expect := json
+	for i, step := range steps {
+		var err, experr error
+		switch step.op {
+		case "set":
+			err = ed.Set(step.path, step.value)
+			expect, experr = Set(expect, step.path, step.value)
+		case "raw":
+			err = ed.SetRaw(step.path, []byte(step.value))
+			expect, experr = SetRaw(expect, step.path, step.value)
+		case "del":
+			err = ed.Delete(step.path)
+			expect, experr = Delete(expect, step.path)
+		}
+		if err != nil || experr != nil {
+			t.Fatalf("%d: %v %v", i, err, experr)
+		}
+		if string(ed.Bytes()) != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, ed.Bytes())
+		}
+	}
+	if err := ed.Set("", 1); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+	if string(ed.Bytes()) != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, ed.Bytes())
+	}
+}
+
+func TestEditorRandom(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rng := rand.New(rand.NewSource(seed))
+	ed := NewEditor(nil)
+	var expect string
+	for i := 0; i < 2000; i++ {
+		path := "k" + strconv.Itoa(rng.Intn(20))
+		if rng.Intn(2) == 0 {
+			path += ".a" + strconv.Itoa(rng.Intn(3))
+		}
+		var err error
+		switch rng.Intn(3) {
+		case 0:
+			err = ed.Delete(path)
+			expect, _ = Delete(expect, path)
+		default:
+			value := make([]byte, rng.Intn(50))
+			for j := range value {
+				value[j] = 'a' + byte(rng.Intn(26))
+			}
+			err = ed.Set(path, string(value))
+			expect, _ = Set(expect, path, string(value))
+		}
+		if err != nil {
+			t.Fatalf("seed %d: %v", seed, err)
+		}
+		if string(ed.Bytes()) != expect {
+			t.Fatalf("seed %d: expected '%v', got '%v'", seed, expect,
+				ed.Bytes())
+		}
+	}
+}
@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/tidwall/gjson v1.14.2
+	github.com/tidwall/match v1.1.1
 	github.com/tidwall/pretty v1.2.0
 )
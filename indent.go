@@ -0,0 +1,122 @@
+package sjson
+
+import (
+	"strings"
+
+	"github.com/tidwall/pretty"
+)
+
+// indentEdit formats the new bytes of an edit to match the indentation of
+// the json around it. Single line documents are left alone.
+func indentEdit(jstr string, e edit) edit {
+	if e.quote || len(e.mid) == 0 || strings.IndexByte(jstr, '\n') == -1 {
+		return e
+	}
+	if e.kind == editValue {
+		if e.mid[0] == '{' || e.mid[0] == '[' {
+			e.mid = indentValue(e.mid, lineIndent(jstr, e.start),
+				indentUnit(jstr))
+		}
+		return e
+	}
+	open, close := "{", "}"
+	if e.kind == editElement {
+		open, close = "[", "]"
+	}
+	// body is the new member or element, without the leading comma and the
+	// closing bracket.
+	body := e.mid[:len(e.mid)-1]
+	empty := body[0] != ','
+	if !empty {
+		body = body[1:]
+	}
+	// keep everything following the closing bracket
+	e.end = e.start + 1
+	if empty {
+		// reformat the entire container
+		e.start = e.open
+		e.mid = indentValue(open+body+close, lineIndent(jstr, e.open),
+			indentUnit(jstr))
+		return e
+	}
+	last := e.start
+	for last > e.open+1 && jstr[last-1] <= ' ' {
+		last--
+	}
+	ws := jstr[last:e.start]
+	nl := strings.LastIndexByte(ws, '\n')
+	if nl == -1 {
+		// the container is on a single line
+		return e
+	}
+	cindent := ws[nl+1:]
+	first := e.open + 1
+	for first < last && jstr[first] <= ' ' {
+		first++
+	}
+	unit := indentUnit(jstr)
+	mindent := lineIndent(jstr, first)
+	if strings.IndexByte(jstr[e.open:first], '\n') != -1 &&
+		len(mindent) > len(cindent) && strings.HasPrefix(mindent, cindent) {
+		unit = mindent[len(cindent):]
+	}
+	var out string
+	if e.kind == editMember {
+		// objects are always expanded, take the lines between the brackets
+		out = indentValue(open+body+close, cindent, unit)
+		out = out[strings.IndexByte(out, '\n')+1 : strings.LastIndexByte(out, '\n')]
+	} else {
+		out = cindent + unit + indentValue(body, cindent+unit, unit)
+	}
+	buf := make([]byte, 0, len(out)+len(ws)+3)
+	buf = append(buf, ',', '\n')
+	buf = append(buf, out...)
+	buf = append(buf, ws...)
+	buf = append(buf, close...)
+	e.start = last
+	e.mid = string(buf)
+	return e
+}
+
+// indentValue formats a json object or array for insertion at a position in
+// a document where the current line begins with the prefix.
+func indentValue(raw, prefix, unit string) string {
+	out := pretty.PrettyOptions([]byte(raw), &pretty.Options{
+		Width: 80, Prefix: prefix, Indent: unit,
+	})
+	return strings.TrimRight(strings.TrimPrefix(string(out), prefix), "\n")
+}
+
+// lineIndent returns the leading whitespace of the line containing the
+// position.
+func lineIndent(jstr string, pos int) string {
+	i := strings.LastIndexByte(jstr[:pos], '\n') + 1
+	j := i
+	for j < pos && (jstr[j] == ' ' || jstr[j] == '\t') {
+		j++
+	}
+	return jstr[i:j]
+}
+
+// indentUnit returns the smallest indentation used by the lines of the json,
+// or two spaces when the json is not indented.
+func indentUnit(jstr string) string {
+	var unit string
+	for i := 0; i < len(jstr); i++ {
+		if jstr[i] != '\n' {
+			continue
+		}
+		j := i + 1
+		for j < len(jstr) && (jstr[j] == ' ' || jstr[j] == '\t') {
+			j++
+		}
+		if j > i+1 && j < len(jstr) && jstr[j] > ' ' &&
+			(unit == "" || j-i-1 < len(unit)) {
+			unit = jstr[i+1 : j]
+		}
+	}
+	if unit == "" {
+		return "  "
+	}
+	return unit
+}
@@ -0,0 +1,53 @@
+package sjson
+
+import "testing"
+
+func TestPreserveIndent(t *testing.T) {
+	json := "{\n  \"name\": \"Tom\",\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {}\n}\n"
+	tests := []struct {
+		path   string
+		value  interface{}
+		expect string
+	}{
+		{"age", 37,
+			"{\n  \"name\": \"Tom\",\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {},\n  \"age\": 37\n}\n"},
+		{"a.b", map[string]int{"c": 1},
+			"{\n  \"name\": \"Tom\",\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {},\n  \"a\": {\n    \"b\": {\n      \"c\": 1\n    }\n  }\n}\n"},
+		{"list.-1", 3,
+			"{\n  \"name\": \"Tom\",\n  \"list\": [\n    1,\n    2,\n    3\n  ],\n  \"empty\": {}\n}\n"},
+		{"empty.x", true,
+			"{\n  \"name\": \"Tom\",\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {\n    \"x\": true\n  }\n}\n"},
+		{"name", map[string]string{"first": "Tom"},
+			"{\n  \"name\": {\n    \"first\": \"Tom\"\n  },\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {}\n}\n"},
+		{"name", "Sara",
+			"{\n  \"name\": \"Sara\",\n  \"list\": [\n    1,\n    2\n  ],\n  \"empty\": {}\n}\n"},
+	}
+	opts := &Options{PreserveIndent: true}
+	for i, tt := range tests {
+		res, err := SetOptions(json, tt.path, tt.value, opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected\n%v\ngot\n%v", i, tt.expect, res)
+		}
+	}
+	// tabs
+	json = "{\n\t\"a\": {\n\t\t\"b\": 1\n\t}\n}"
+	res, err := SetOptions(json, "a.c", 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "{\n\t\"a\": {\n\t\t\"b\": 1,\n\t\t\"c\": 2\n\t}\n}"
+	if res != expect {
+		t.Fatalf("expected\n%v\ngot\n%v", expect, res)
+	}
+	// single line documents are unchanged
+	res, err = SetOptions(`{"a":{"b":1}}`, "a.c", 2, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"a":{"b":1,"c":2}}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+}
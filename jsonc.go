@@ -0,0 +1,100 @@
+package sjson
+
+import "unsafe"
+
+// SetJSONC sets a json value for the specified path of a JSONC document,
+// which is json that may have "//" line comments, "/* */" block comments,
+// and trailing commas. The comments and trailing commas outside of the
+// edited region are preserved as they are.
+func SetJSONC(json, path string, value interface{}) (string, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	return setJSONC(json, path, raw, stringify, del)
+}
+
+// SetRawJSONC sets a raw json value for the specified path of a JSONC
+// document.
+func SetRawJSONC(json, path, value string) (string, error) {
+	return setJSONC(json, path, value, false, false)
+}
+
+// DeleteJSONC deletes a value for the specified path of a JSONC document.
+// Comments that are between the deleted value and its neighbors are removed
+// along with it.
+func DeleteJSONC(json, path string) (string, error) {
+	return setJSONC(json, path, "", false, true)
+}
+
+func setJSONC(json, path, raw string, stringify, del bool) (string, error) {
+	// the edit is found using a copy of the document where the comments
+	// are replaced by spaces, which keeps the offsets the same
+	masked, ok := maskComments(json)
+	if !ok {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidJSON}
+	}
+	mstr := *(*string)(unsafe.Pointer(&masked))
+	e, err := pathEdit(mstr, path, raw, stringify, del, nil)
+	if err == errNoChange {
+		return json, nil
+	}
+	if err != nil {
+		return json, err
+	}
+	// keep the leading and trailing comments of the document
+	e.lead = 0
+	if e.kind != editValue && e.start < len(masked) &&
+		(masked[e.start] == '}' || masked[e.start] == ']') {
+		e.end = e.start + 1
+	}
+	if e.kind != editValue && len(e.mid) > 0 && e.mid[0] == ',' {
+		// don't add a comma after a trailing comma
+		i := e.start - 1
+		for ; i >= 0 && masked[i] <= ' '; i-- {
+		}
+		if i >= 0 && masked[i] == ',' {
+			e.mid = e.mid[1:]
+		}
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), nil
+}
+
+// maskComments returns a copy of the json where the comments are replaced
+// by spaces. False is returned when a block comment is not closed.
+func maskComments(json string) ([]byte, bool) {
+	buf := []byte(json)
+	for i := 0; i < len(buf); i++ {
+		switch buf[i] {
+		case '"':
+			for i++; i < len(buf) && buf[i] != '"'; i++ {
+				if buf[i] == '\\' {
+					i++
+				}
+			}
+		case '/':
+			if i+1 == len(buf) {
+				break
+			}
+			if buf[i+1] == '/' {
+				for ; i < len(buf) && buf[i] != '\n'; i++ {
+					buf[i] = ' '
+				}
+			} else if buf[i+1] == '*' {
+				buf[i], buf[i+1] = ' ', ' '
+				for i += 2; ; i++ {
+					if i+1 >= len(buf) {
+						return buf, false
+					}
+					if buf[i] == '*' && buf[i+1] == '/' {
+						buf[i], buf[i+1] = ' ', ' '
+						i++
+						break
+					}
+					buf[i] = ' '
+				}
+			}
+		}
+	}
+	return buf, true
+}
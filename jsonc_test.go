@@ -0,0 +1,129 @@
+package sjson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetJSONC(t *testing.T) {
+	json := `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 8080,
+  "tags": [
+    "a", // first
+    "b",
+  ],
+  "url": "http://x//y/*z*/",
+}
+`
+	tests := []struct {
+		path   string
+		value  interface{}
+		expect string
+	}{
+		{"port", 9090, `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 9090,
+  "tags": [
+    "a", // first
+    "b",
+  ],
+  "url": "http://x//y/*z*/",
+}
+`},
+		{"tags.-1", "c", `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 8080,
+  "tags": [
+    "a", // first
+    "b",
+  "c"],
+  "url": "http://x//y/*z*/",
+}
+`},
+		{"debug", true, `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 8080,
+  "tags": [
+    "a", // first
+    "b",
+  ],
+  "url": "http://x//y/*z*/",
+"debug":true}
+`},
+		{"url", "z", `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 8080,
+  "tags": [
+    "a", // first
+    "b",
+  ],
+  "url": "z",
+}
+`},
+		{"tags.0", dtype{}, `// settings
+{
+  "name": "app", // the name
+  /* the "port", with a comment {,} */
+  "port": 8080,
+  "tags": [
+     // first
+    "b",
+  ],
+  "url": "http://x//y/*z*/",
+}
+`},
+	}
+	for i, tt := range tests {
+		res, err := SetJSONC(json, tt.path, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	padded := []struct {
+		json, expect string
+	}{
+		{"{\"a\":[1, // one\n 2]}", "{\"a\":[1, // one\n 2,null,null,5]}"},
+		{`{"a":[{"x":1 /* keep */}]}`,
+			`{"a":[{"x":1 /* keep */},null,null,null,5]}`},
+		{"{\"a\":[1, /* c */ 2, // t\n]}",
+			"{\"a\":[1, /* c */ 2, // t\nnull,null,5]}"},
+		{`{"a":[ /* empty */ ]}`, `{"a":[ /* empty */ null,null,null,null,5]}`},
+	}
+	for i, tt := range padded {
+		res, err := SetJSONC(tt.json, "a.4", 5)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	res, err := SetRawJSONC(`{"a":[1,2,],/*x*/}`, "a.-1", `3`)
+	if err != nil || res != `{"a":[1,2,3],/*x*/}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = DeleteJSONC(`{"a":1, /* b */ "b":2}`, "b")
+	if err != nil || res != `{"a":1}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = DeleteJSONC(`{"a":1}`, "missing")
+	if err != nil || res != `{"a":1}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = SetJSONC(`/* unterminated {"a":1}`, "a", 2)
+	if !errors.Is(err, ErrInvalidJSON) || res != `/* unterminated {"a":1}` {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidJSON, err)
+	}
+}
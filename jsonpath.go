@@ -0,0 +1,96 @@
+package sjson
+
+import "strconv"
+
+// SetJSONPath sets a json value for the JSONPath expression, such as
+// "$.store.book[0].title" or "$['store']['book'][*].title". Dot and bracket
+// notation, array indexes, and "[*]" for every element of an array are
+// supported. The "[*]" component is expanded in the same way as the "#"
+// component of SetAll. Other features, such as filters, slices, unions,
+// recursive descent, and negative indexes, return ErrUnsupportedExpression.
+func SetJSONPath(json, jsonpath string, value interface{}) (string, error) {
+	path, err := jsonPathToPath(jsonpath)
+	if err != nil {
+		return json, err
+	}
+	return SetAll(json, path, value)
+}
+
+// jsonPathToPath translates a JSONPath expression into a path.
+func jsonPathToPath(jsonpath string) (string, error) {
+	unsupported := &PathError{Path: jsonpath, Offset: -1,
+		Err: ErrUnsupportedExpression}
+	if len(jsonpath) == 0 || jsonpath[0] != '$' {
+		return "", unsupported
+	}
+	var path string
+	for i := 1; i < len(jsonpath); {
+		var part string
+		switch jsonpath[i] {
+		case '.':
+			i++
+			start := i
+			for ; i < len(jsonpath); i++ {
+				if jsonpath[i] == '.' || jsonpath[i] == '[' {
+					break
+				}
+			}
+			name := jsonpath[start:i]
+			if name == "" || name == "*" {
+				// recursive descent or a member wildcard
+				return "", unsupported
+			}
+			part = escapeKey(name)
+		case '[':
+			i++
+			if i == len(jsonpath) {
+				return "", unsupported
+			}
+			switch c := jsonpath[i]; {
+			case c == '\'' || c == '"':
+				// quoted member name
+				var name []byte
+				i++
+				for ; i < len(jsonpath) && jsonpath[i] != c; i++ {
+					if jsonpath[i] == '\\' && i+1 < len(jsonpath) {
+						i++
+					}
+					name = append(name, jsonpath[i])
+				}
+				if i == len(jsonpath) {
+					return "", unsupported
+				}
+				i++
+				part = escapeKey(string(name))
+			case c == '*':
+				i++
+				part = "#"
+			case c >= '0' && c <= '9':
+				start := i
+				for ; i < len(jsonpath); i++ {
+					if jsonpath[i] < '0' || jsonpath[i] > '9' {
+						break
+					}
+				}
+				n, err := strconv.Atoi(jsonpath[start:i])
+				if err != nil {
+					return "", unsupported
+				}
+				part = strconv.Itoa(n)
+			default:
+				return "", unsupported
+			}
+			if i == len(jsonpath) || jsonpath[i] != ']' {
+				return "", unsupported
+			}
+			i++
+		default:
+			return "", unsupported
+		}
+		path = joinPath(path, part)
+	}
+	if path == "" {
+		return "", &PathError{Path: jsonpath, Offset: -1, Err: ErrEmptyPath}
+	}
+	return path, nil
+}
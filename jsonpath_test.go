@@ -0,0 +1,48 @@
+package sjson
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSetJSONPath(t *testing.T) {
+	json := `{"store":{"book":[{"title":"a"},{"title":"b"}],"x.y":{}}}`
+	tests := []struct {
+		jsonpath string
+		expect   string
+	}{
+		{"$.store.book[0].title",
+			`{"store":{"book":[{"title":"Z"},{"title":"b"}],"x.y":{}}}`},
+		{"$['store']['book'][1][\"title\"]",
+			`{"store":{"book":[{"title":"a"},{"title":"Z"}],"x.y":{}}}`},
+		{"$.store.book[*].title",
+			`{"store":{"book":[{"title":"Z"},{"title":"Z"}],"x.y":{}}}`},
+		{"$.store['x.y'].z",
+			`{"store":{"book":[{"title":"a"},{"title":"b"}],"x.y":{"z":"Z"}}}`},
+		{"$.store.book[2]",
+			`{"store":{"book":[{"title":"a"},{"title":"b"},"Z"],"x.y":{}}}`},
+		{"$.new[0].1",
+			`{"store":{"book":[{"title":"a"},{"title":"b"}],"x.y":{}},` +
+				`"new":[{"1":"Z"}]}`},
+		{`$['it\'s']`,
+			`{"store":{"book":[{"title":"a"},{"title":"b"}],"x.y":{}},` +
+				`"it's":"Z"}`},
+	}
+	for i, tt := range tests {
+		res, err := SetJSONPath(json, tt.jsonpath, "Z")
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	for _, jsonpath := range []string{"store.book", "$..title", "$.store.*",
+		"$.store.book[-1]", "$.store.book[0:1]", "$.store.book[0,1]",
+		"$.store.book[?(@.title)]", "$.store.book[0", "$['store]", "$x"} {
+		res, err := SetJSONPath(json, jsonpath, "Z")
+		if !errors.Is(err, ErrUnsupportedExpression) || res != json {
+			t.Fatalf("%s: unexpected '%v' (%v)", jsonpath, res, err)
+		}
+	}
+	if _, err := SetJSONPath(json, "$", "Z"); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
@@ -0,0 +1,185 @@
+package sjson
+
+import (
+	"strconv"
+	"unsafe"
+
+	"github.com/tidwall/gjson"
+)
+
+// MergePatch applies a JSON Merge Patch, as described in RFC 7386, to the
+// target json.
+// Objects in the patch are merged recursively into the target, a null patch
+// value removes the matching key, and all other values, including arrays,
+// replace the target value wholesale. When the patch is not an object the
+// result is the patch itself, and when the target is not an object it's
+// treated as an empty object.
+//
+// Patch keys are always taken literally, so a key such as "fav.movie" is not
+// interpreted as a path. Parts of the target that are not touched by the
+// patch are left byte-for-byte identical.
+func MergePatch(target, patch string) (string, error) {
+	if !gjson.Valid(patch) {
+		return target, &errorType{"invalid patch"}
+	}
+	return mergePatch(target, gjson.Parse(patch))
+}
+
+// MergePatchBytes applies a JSON Merge Patch to the target json.
+// If working with bytes, this method preferred over
+// MergePatch(string(target), string(patch))
+func MergePatchBytes(target, patch []byte) ([]byte, error) {
+	tstr := *(*string)(unsafe.Pointer(&target))
+	pstr := *(*string)(unsafe.Pointer(&patch))
+	res, err := MergePatch(tstr, pstr)
+	if err != nil {
+		return target, err
+	}
+	return []byte(res), nil
+}
+
+func mergePatch(target string, patch gjson.Result) (string, error) {
+	if !patch.IsObject() {
+		return patch.Raw, nil
+	}
+	if !gjson.Parse(target).IsObject() {
+		target = "{}"
+	}
+	return mergePatchObject(target, "", patch)
+}
+
+// mergePatchObject merges the patch object into the object at the prefix
+// path, which is empty for the root or otherwise ends with a dot.
+func mergePatchObject(json, prefix string, patch gjson.Result) (string, error) {
+	var err error
+	patch.ForEach(func(key, value gjson.Result) bool {
+		path := prefix + escapeKey(key.String())
+		switch {
+		case value.Type == gjson.Null:
+			json, err = Delete(json, path)
+		case value.IsObject():
+			if getPath(json, path).IsObject() {
+				json, err = mergePatchObject(json, path+".", value)
+				break
+			}
+			// the patch object replaces the target value, but it must
+			// first be stripped of its null members.
+			var raw string
+			raw, err = mergePatchObject("{}", "", value)
+			if err == nil {
+				json, err = SetRaw(json, path, raw)
+			}
+		default:
+			json, err = SetRaw(json, path, value.Raw)
+		}
+		return err == nil
+	})
+	return json, err
+}
+
+// ArrayMerge is how Merge combines two arrays.
+type ArrayMerge int
+
+const (
+	// ArrayReplace replaces the array with the new one.
+	ArrayReplace ArrayMerge = iota
+	// ArrayConcat appends the elements of the new array to the array.
+	ArrayConcat
+	// ArrayMergeByIndex merges each element of the new array into the
+	// element at the same index, appending the elements past the end.
+	ArrayMergeByIndex
+)
+
+// MergeOptions represents additional options for the Merge function.
+type MergeOptions struct {
+	// Arrays is how arrays are merged. The default is ArrayReplace.
+	Arrays ArrayMerge
+	// IgnoreNulls skips the null values of the merged document, rather
+	// than writing them as null.
+	IgnoreNulls bool
+}
+
+// Merge deep merges the json document b into a. Objects are merged
+// recursively, arrays are merged as set by the Arrays option, and all other
+// values of b replace the values of a, including when the types conflict.
+//
+// Unlike MergePatch, a null value in b is written as null unless the
+// IgnoreNulls option is set, and keys are never deleted. Parts of a that
+// are not touched by b are left byte-for-byte identical.
+func Merge(a, b string, opts *MergeOptions) (string, error) {
+	if !gjson.Valid(b) {
+		return a, ErrInvalidJSON
+	}
+	if opts == nil {
+		opts = &MergeOptions{}
+	}
+	res, err := mergeValue(a, "", gjson.Parse(a), gjson.Parse(b), opts)
+	if err != nil {
+		return a, err
+	}
+	return res, nil
+}
+
+// MergeBytes deep merges the json document b into a.
+// If working with bytes, this method preferred over
+// Merge(string(a), string(b), opts)
+func MergeBytes(a, b []byte, opts *MergeOptions) ([]byte, error) {
+	astr := *(*string)(unsafe.Pointer(&a))
+	bstr := *(*string)(unsafe.Pointer(&b))
+	res, err := Merge(astr, bstr, opts)
+	if err != nil {
+		return a, err
+	}
+	return []byte(res), nil
+}
+
+// mergeValue merges the value into cur, which is the value at the path of
+// the json. The path is empty for the root.
+func mergeValue(json, path string, cur, value gjson.Result,
+	opts *MergeOptions) (string, error) {
+	var err error
+	switch {
+	case value.Type == gjson.Null && opts.IgnoreNulls:
+		return json, nil
+	case value.IsObject() && (cur.IsObject() || opts.IgnoreNulls):
+		if !cur.IsObject() {
+			// start with an empty object so that nulls are left out
+			if json, err = mergeSet(json, path, "{}"); err != nil {
+				return json, err
+			}
+		}
+		value.ForEach(func(key, member gjson.Result) bool {
+			kpath := joinPath(path, escapeKey(key.String()))
+			json, err = mergeValue(json, kpath, getPath(json, kpath), member,
+				opts)
+			return err == nil
+		})
+		return json, err
+	case value.IsArray() && cur.IsArray() && opts.Arrays == ArrayConcat:
+		value.ForEach(func(_, elem gjson.Result) bool {
+			json, err = SetRaw(json, joinPath(path, "-1"), elem.Raw)
+			return err == nil
+		})
+		return json, err
+	case value.IsArray() && cur.IsArray() && opts.Arrays == ArrayMergeByIndex:
+		var i int
+		value.ForEach(func(_, elem gjson.Result) bool {
+			ipath := joinPath(path, strconv.Itoa(i))
+			i++
+			json, err = mergeValue(json, ipath, getPath(json, ipath), elem,
+				opts)
+			return err == nil
+		})
+		return json, err
+	}
+	return mergeSet(json, path, value.Raw)
+}
+
+// mergeSet sets the raw value at the path, replacing the whole document when
+// the path is empty.
+func mergeSet(json, path, raw string) (string, error) {
+	if path == "" {
+		return raw, nil
+	}
+	return SetRaw(json, path, raw)
+}
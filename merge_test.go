@@ -0,0 +1,129 @@
+package sjson
+
+import "testing"
+
+func TestMergePatch(t *testing.T) {
+	// test cases from RFC 7386 Appendix A
+	tests := []struct {
+		target, patch, expect string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// keys are literal
+		{`{"fav.movie":"Deer Hunter"}`, `{"fav.movie":"Taxi Driver"}`,
+			`{"fav.movie":"Taxi Driver"}`},
+		{`{"a":{"b":1}}`, `{"a.b":2}`, `{"a":{"b":1},"a.b":2}`},
+		{`{}`, `{"1":{"2":true}}`, `{"1":{"2":true}}`},
+		{`{"1":{"a":1}}`, `{"1":{"b":2}}`, `{"1":{"a":1,"b":2}}`},
+		{`{"#":1,"*":2}`, `{"#":null,"*":3,":x":4}`, `{"*":3,":x":4}`},
+		{``, `{"a":1}`, `{"a":1}`},
+	}
+	for i, tt := range tests {
+		res, err := MergePatch(tt.target, tt.patch)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if sortJSON(res) != sortJSON(tt.expect) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := MergePatchBytes([]byte(tt.target), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(bres) != res {
+			t.Fatalf("%d: expected '%v', got '%v'", i, res, string(bres))
+		}
+	}
+	if _, err := MergePatch(`{}`, `{"a":`); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestMergePatchUntouched(t *testing.T) {
+	json := `{"big" : [ 1.0, 2e3 , {"x" :  "y"} ], "a":{"b" : 1}}`
+	res, err := MergePatch(json, `{"a":{"c":2}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"big" : [ 1.0, 2e3 , {"x" :  "y"} ], "a":{"b" : 1,"c":2}}`
+	if res != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, res)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		a, b   string
+		opts   *MergeOptions
+		expect string
+	}{
+		{`{"a":1,"b":{"c":2,"d":3}}`, `{"b":{"c":4,"e":5},"f":6}`, nil,
+			`{"a":1,"b":{"c":4,"d":3,"e":5},"f":6}`},
+		{`{"a":1,"b":2}`, `{"a":null}`, nil, `{"a":null,"b":2}`},
+		{`{"a":1,"b":2}`, `{"a":null}`, &MergeOptions{IgnoreNulls: true},
+			`{"a":1,"b":2}`},
+		{`{}`, `{"a":{"b":null,"c":1}}`, &MergeOptions{IgnoreNulls: true},
+			`{"a":{"c":1}}`},
+		{`{}`, `{"a":{"b":null,"c":1}}`, nil, `{"a":{"b":null,"c":1}}`},
+		// conflicting types prefer b
+		{`{"a":{"b":1}}`, `{"a":[1]}`, nil, `{"a":[1]}`},
+		{`{"a":[1]}`, `{"a":{"b":1}}`, nil, `{"a":{"b":1}}`},
+		{`{"a":"x"}`, `{"a":{"b":1}}`, &MergeOptions{IgnoreNulls: true},
+			`{"a":{"b":1}}`},
+		// arrays
+		{`{"a":[1,2,3]}`, `{"a":[4]}`, nil, `{"a":[4]}`},
+		{`{"a":[1,2,3]}`, `{"a":[4,5]}`, &MergeOptions{Arrays: ArrayConcat},
+			`{"a":[1,2,3,4,5]}`},
+		{`{"a":[1,{"x":1},3]}`, `{"a":[4,{"y":2}]}`,
+			&MergeOptions{Arrays: ArrayMergeByIndex},
+			`{"a":[4,{"x":1,"y":2},3]}`},
+		{`{"a":[1]}`, `{"a":[null,2,3]}`,
+			&MergeOptions{Arrays: ArrayMergeByIndex, IgnoreNulls: true},
+			`{"a":[1,2,3]}`},
+		{`{"a":"x"}`, `{"a":[1]}`, &MergeOptions{Arrays: ArrayConcat},
+			`{"a":[1]}`},
+		// root values
+		{`[1,2]`, `[3]`, &MergeOptions{Arrays: ArrayConcat}, `[1,2,3]`},
+		{`[1,2]`, `[3]`, &MergeOptions{Arrays: ArrayMergeByIndex}, `[3,2]`},
+		{`{"a":1}`, `[3]`, nil, `[3]`},
+		{`{"a":1}`, `null`, &MergeOptions{IgnoreNulls: true}, `{"a":1}`},
+		{`{"a":1}`, `null`, nil, `null`},
+		{``, `{"a":1}`, nil, `{"a":1}`},
+		// keys are literal
+		{`{"a":{"b":1}}`, `{"a.b":2,"1":3}`, nil, `{"a":{"b":1},"a.b":2,"1":3}`},
+	}
+	for i, tt := range tests {
+		res, err := Merge(tt.a, tt.b, tt.opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if sortJSON(res) != sortJSON(tt.expect) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := MergeBytes([]byte(tt.a), []byte(tt.b), tt.opts)
+		if err != nil || string(bres) != res {
+			t.Fatalf("%d: expected '%v', got '%v'", i, res, string(bres))
+		}
+	}
+	json := `{"big" : [ 1.0, 2e3 ], "a":{"b" : 1}}`
+	res, err := Merge(json, `{"a":{"b":2}}`, nil)
+	if err != nil || res != `{"big" : [ 1.0, 2e3 ], "a":{"b" : 2}}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	if _, err := Merge(`{}`, `{"a":`, nil); err != ErrInvalidJSON {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidJSON, err)
+	}
+}
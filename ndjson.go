@@ -0,0 +1,68 @@
+package sjson
+
+import (
+	"bytes"
+	"fmt"
+	"unsafe"
+
+	"github.com/tidwall/gjson"
+)
+
+// SetNDJSON sets a json value for the specified path in each line of the
+// newline-delimited json data for which lineSelector returns true. The
+// selector is called with the index of the line in data, starting at zero,
+// and the parsed line. A nil selector selects every line. Each line is
+// edited independently, and the line endings, including "\r\n", empty
+// lines, and a trailing newline are preserved. An error identifying the
+// index of the line is returned when a value cannot be set.
+func SetNDJSON(data []byte, lineSelector func(i int, line gjson.Result) bool,
+	path string, value interface{}) ([]byte, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return data, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	return setNDJSON(data, lineSelector, path, raw, stringify, del)
+}
+
+// DeleteNDJSON deletes a value for the specified path in each line of the
+// newline-delimited json data for which lineSelector returns true.
+func DeleteNDJSON(data []byte, lineSelector func(i int, line gjson.Result) bool,
+	path string) ([]byte, error) {
+	return setNDJSON(data, lineSelector, path, "", false, true)
+}
+
+func setNDJSON(data []byte, lineSelector func(i int, line gjson.Result) bool,
+	path, raw string, stringify, del bool) ([]byte, error) {
+	buf := make([]byte, 0, len(data))
+	for i := 0; len(data) > 0; i++ {
+		line := data
+		j := bytes.IndexByte(data, '\n')
+		if j != -1 {
+			line, data = data[:j], data[j+1:]
+		} else {
+			data = nil
+		}
+		var cr []byte
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line, cr = line[:len(line)-1], line[len(line)-1:]
+		}
+		jstr := *(*string)(unsafe.Pointer(&line))
+		if len(trim(jstr)) > 0 &&
+			(lineSelector == nil || lineSelector(i, gjson.Parse(jstr))) {
+			res, err := set(jstr, path, raw, stringify, del, nil)
+			if err == errNoChange {
+				res, err = line, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i, err)
+			}
+			line = res
+		}
+		buf = append(buf, line...)
+		buf = append(buf, cr...)
+		if j != -1 {
+			buf = append(buf, '\n')
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,39 @@
+package sjson
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestSetNDJSON(t *testing.T) {
+	data := "{\"id\":1,\"t\":\"a\"}\n\n{\"id\":2,\"t\":\"b\"}\r\n  \n{\"id\":3}\n"
+	var lines []int
+	res, err := SetNDJSON([]byte(data), func(i int, line gjson.Result) bool {
+		lines = append(lines, i)
+		return line.Get("id").Int() != 2
+	}, "seen", true)
+	expect := "{\"id\":1,\"t\":\"a\",\"seen\":true}\n\n{\"id\":2,\"t\":\"b\"}" +
+		"\r\n  \n{\"id\":3,\"seen\":true}\n"
+	if err != nil || string(res) != expect {
+		t.Fatalf("expected %q, got %q (%v)", expect, res, err)
+	}
+	if len(lines) != 3 || lines[0] != 0 || lines[1] != 2 || lines[2] != 4 {
+		t.Fatalf("unexpected lines %v", lines)
+	}
+	res, err = SetNDJSON([]byte("{\"a\":1}\r\n{\"a\":2}"), nil, "b", 0)
+	if err != nil || string(res) != "{\"a\":1,\"b\":0}\r\n{\"a\":2,\"b\":0}" {
+		t.Fatalf("unexpected %q (%v)", res, err)
+	}
+	res, err = DeleteNDJSON([]byte(data), nil, "t")
+	expect = "{\"id\":1}\n\n{\"id\":2}\r\n  \n{\"id\":3}\n"
+	if err != nil || string(res) != expect {
+		t.Fatalf("expected %q, got %q (%v)", expect, res, err)
+	}
+	_, err = SetNDJSON([]byte("{}\n{\"a\":[]}\n"), nil, "a.b", 1)
+	if !errors.Is(err, ErrInvalidPath) || err.Error() !=
+		"line 1: invalid path at 'a.b'" {
+		t.Fatalf("unexpected %v", err)
+	}
+}
@@ -0,0 +1,371 @@
+package sjson
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/tidwall/gjson"
+)
+
+// ApplyPatch applies a JSON Patch, as described in RFC 6902, to the json.
+// The patch is an array of "add", "remove", "replace", "move", "copy", and
+// "test" operations which are applied in order. The paths in the operations
+// are JSON Pointers, such as "/friends/0/last".
+//
+// The operations are all or nothing. When an operation fails, such as a
+// "test" that does not match or a "remove" of a value that does not exist,
+// the original json is returned along with an error identifying the index
+// of the failed operation.
+func ApplyPatch(json, patch string) (string, error) {
+	if !gjson.Valid(patch) {
+		return json, &errorType{"invalid patch"}
+	}
+	ops := gjson.Parse(patch)
+	if !ops.IsArray() {
+		return json, &errorType{"patch must be an array"}
+	}
+	res := json
+	var err error
+	var i int
+	ops.ForEach(func(_, op gjson.Result) bool {
+		res, err = applyPatchOp(res, op)
+		if err != nil {
+			err = fmt.Errorf("patch operation %d: %w", i, err)
+			return false
+		}
+		i++
+		return true
+	})
+	if err != nil {
+		return json, err
+	}
+	return res, nil
+}
+
+// ApplyPatchBytes applies a JSON Patch to the json.
+// If working with bytes, this method preferred over
+// ApplyPatch(string(data), string(patch))
+func ApplyPatchBytes(json, patch []byte) ([]byte, error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	pstr := *(*string)(unsafe.Pointer(&patch))
+	res, err := ApplyPatch(jstr, pstr)
+	if err != nil {
+		return json, err
+	}
+	return []byte(res), nil
+}
+
+func applyPatchOp(json string, op gjson.Result) (string, error) {
+	if !op.IsObject() {
+		return json, &errorType{"operation must be an object"}
+	}
+	path := op.Get("path")
+	if path.Type != gjson.String {
+		return json, &errorType{"missing path"}
+	}
+	pointer := path.String()
+	switch kind := op.Get("op").String(); kind {
+	case "add", "replace", "test":
+		value := op.Get("value")
+		if !value.Exists() {
+			return json, &errorType{"missing value"}
+		}
+		switch kind {
+		case "add":
+			return patchAdd(json, pointer, value.Raw)
+		case "replace":
+			return patchReplace(json, pointer, value.Raw)
+		}
+		cur, err := patchGet(json, pointer)
+		if err != nil {
+			return json, err
+		}
+		if !jsonEqual(cur, value) {
+			return json, &errorType{"test failed for '" + pointer + "'"}
+		}
+		return json, nil
+	case "remove":
+		return patchRemove(json, pointer)
+	case "move", "copy":
+		from := op.Get("from")
+		if from.Type != gjson.String {
+			return json, &errorType{"missing from"}
+		}
+		value, err := patchGet(json, from.String())
+		if err != nil {
+			return json, err
+		}
+		if kind == "move" {
+			if from.String() == pointer {
+				return json, nil
+			}
+			if strings.HasPrefix(pointer, from.String()+"/") {
+				return json, &errorType{
+					"cannot move '" + from.String() + "' into itself"}
+			}
+			json, err = patchRemove(json, from.String())
+			if err != nil {
+				return json, err
+			}
+		}
+		return patchAdd(json, pointer, value.Raw)
+	default:
+		return json, &errorType{"unknown operation '" + kind + "'"}
+	}
+}
+
+// pointerTarget is the location in a json document referenced by a JSON
+// Pointer.
+type pointerTarget struct {
+	root   bool         // the pointer references the whole document
+	path   string       // sjson path to the target
+	parent gjson.Result // container of the target
+	index  int          // array index of the target, or -1 for the end
+	value  gjson.Result // current target value
+}
+
+// resolvePointer translates a JSON Pointer into an sjson path. The parent
+// container of the target must exist.
+func resolvePointer(json, pointer string) (t pointerTarget, err error) {
+	if pointer == "" {
+		t.root = true
+		t.value = gjson.Parse(json)
+		return t, nil
+	}
+	if pointer[0] != '/' {
+		return t, &errorType{"invalid pointer '" + pointer + "'"}
+	}
+	comps := strings.Split(pointer[1:], "/")
+	cur := gjson.Parse(json)
+	for i, comp := range comps {
+		if !cur.IsObject() && !cur.IsArray() {
+			return t, &errorType{"path '" + pointer + "' not found"}
+		}
+		comp = strings.Replace(comp, "~1", "/", -1)
+		comp = strings.Replace(comp, "~0", "~", -1)
+		var part string
+		t.parent = cur
+		t.index = 0
+		if cur.IsArray() {
+			if comp == "-" {
+				if i < len(comps)-1 {
+					return t, &errorType{"path '" + pointer + "' not found"}
+				}
+				t.index = -1
+				part = "-1"
+			} else {
+				n, ok := atoui(pathResult{part: comp})
+				if !ok || comp == "" || (comp[0] == '0' && len(comp) > 1) {
+					return t, &PathError{Path: pointer, Offset: -1,
+						Err: ErrInvalidPath}
+				}
+				t.index = n
+				part = comp
+			}
+		} else {
+			part = escapeKey(comp)
+		}
+		if t.path == "" {
+			t.path = part
+		} else {
+			t.path += "." + part
+		}
+		if t.index == -1 {
+			cur = gjson.Result{}
+		} else {
+			cur = getPath(json, t.path)
+		}
+	}
+	t.value = cur
+	return t, nil
+}
+
+// patchGet returns the existing value referenced by the pointer.
+func patchGet(json, pointer string) (gjson.Result, error) {
+	t, err := resolvePointer(json, pointer)
+	if err != nil {
+		return t.value, err
+	}
+	if !t.value.Exists() {
+		return t.value, &errorType{"path '" + pointer + "' not found"}
+	}
+	return t.value, nil
+}
+
+func patchAdd(json, pointer, raw string) (string, error) {
+	t, err := resolvePointer(json, pointer)
+	if err != nil {
+		return json, err
+	}
+	if t.root {
+		return raw, nil
+	}
+	if t.parent.IsArray() && t.index != -1 {
+		n := len(t.parent.Array())
+		if t.index > n {
+			return json, &PathError{Path: pointer, Offset: t.parent.Index,
+				Err: ErrIndexOutOfRange}
+		}
+		if t.index < n {
+			return string(arrayInsertRaw(json, t.value, raw)), nil
+		}
+	}
+	return SetRaw(json, t.path, raw)
+}
+
+func patchRemove(json, pointer string) (string, error) {
+	t, err := resolvePointer(json, pointer)
+	if err != nil {
+		return json, err
+	}
+	if t.root {
+		return json, &errorType{"cannot remove the root document"}
+	}
+	if !t.value.Exists() {
+		return json, &errorType{"path '" + pointer + "' not found"}
+	}
+	return Delete(json, t.path)
+}
+
+func patchReplace(json, pointer, raw string) (string, error) {
+	t, err := resolvePointer(json, pointer)
+	if err != nil {
+		return json, err
+	}
+	if t.root {
+		return raw, nil
+	}
+	if !t.value.Exists() {
+		return json, &errorType{"path '" + pointer + "' not found"}
+	}
+	return SetRaw(json, t.path, raw)
+}
+
+// PatchOp is a single JSON Patch operation, as described in RFC 6902.
+type PatchOp struct {
+	Op    string // "add" or "replace"
+	Path  string // JSON Pointer, such as "/friends/0/last"
+	Value string // raw json value
+}
+
+// String returns the operation as a json object, which may be used as an
+// element of the patch passed to ApplyPatch.
+func (op PatchOp) String() string {
+	buf := []byte(`{"op":`)
+	buf = appendStringify(buf, op.Op)
+	buf = append(buf, `,"path":`...)
+	buf = appendStringify(buf, op.Path)
+	buf = append(buf, `,"value":`...)
+	buf = append(buf, op.Value...)
+	buf = append(buf, '}')
+	return string(buf)
+}
+
+// SetDiff sets a json value for the specified path and returns the JSON
+// Patch operation that describes the change. The operation is "replace"
+// when the path exists, and "add" when it's new. When parent containers are
+// created by the set, the "add" is for the outermost new container, so that
+// the operation can be applied to the original json with ApplyPatch.
+// The path must be simple, without queries, wildcards, or modifiers.
+func SetDiff(json, path string, value interface{}) (string, PatchOp, error) {
+	paths, simple := splitPath(path)
+	if path != "" && !simple {
+		return json, PatchOp{},
+			&PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	res, err := Set(json, path, value)
+	if err != nil {
+		return res, PatchOp{}, err
+	}
+	op := PatchOp{Op: "replace"}
+	var spath string
+	cur := gjson.Parse(json)
+	for _, r := range paths {
+		if !cur.IsObject() && !cur.IsArray() {
+			// the value is replaced by a new container
+			if !cur.Exists() {
+				op.Op = "add"
+			}
+			break
+		}
+		var part string
+		if cur.IsArray() {
+			n, ok := atoui(r)
+			if !ok {
+				// appended with -1
+				n, ok = len(cur.Array()), true
+			}
+			if elems := cur.Array(); n > len(elems) {
+				// the array is padded with nulls
+				break
+			} else if n == len(elems) {
+				op.Op = "add"
+			}
+			part = strconv.Itoa(n)
+			op.Path += "/" + part
+		} else {
+			part = escapeKey(r.part)
+			op.Path += "/" + strings.Replace(strings.Replace(r.part, "~",
+				"~0", -1), "/", "~1", -1)
+		}
+		spath = joinPath(spath, part)
+		if op.Op == "add" {
+			break
+		}
+		if cur = getPath(json, spath); !cur.Exists() {
+			op.Op = "add"
+			break
+		}
+	}
+	if spath == "" {
+		op.Value = trim(res)
+	} else {
+		op.Value = getPath(res, spath).Raw
+	}
+	return res, op, nil
+}
+
+// jsonEqual returns true if both values are equal json values. Numbers are
+// compared by value, object members are compared regardless of their order,
+// and insignificant whitespace is ignored.
+func jsonEqual(a, b gjson.Result) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	switch a.Type {
+	case gjson.Number:
+		return a.Num == b.Num
+	case gjson.String:
+		return a.Str == b.Str
+	case gjson.JSON:
+		if a.IsArray() != b.IsArray() {
+			return false
+		}
+		if a.IsArray() {
+			aa, ba := a.Array(), b.Array()
+			if len(aa) != len(ba) {
+				return false
+			}
+			for i := range aa {
+				if !jsonEqual(aa[i], ba[i]) {
+					return false
+				}
+			}
+			return true
+		}
+		am, bm := a.Map(), b.Map()
+		if len(am) != len(bm) {
+			return false
+		}
+		for k, av := range am {
+			bv, ok := bm[k]
+			if !ok || !jsonEqual(av, bv) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
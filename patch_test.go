@@ -0,0 +1,170 @@
+package sjson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestApplyPatch(t *testing.T) {
+	// test cases from RFC 6902 Appendix A
+	tests := []struct {
+		json, patch, expect string
+	}{
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux"}]`,
+			`{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`,
+			`[{"op":"add","path":"/foo/1","value":"qux"}]`,
+			`{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"remove","path":"/baz"}]`,
+			`{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`,
+			`[{"op":"remove","path":"/foo/1"}]`,
+			`{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`,
+			`[{"op":"replace","path":"/baz","value":"boo"}]`,
+			`{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`,
+			`[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`,
+			`[{"op":"move","from":"/foo/1","path":"/foo/3"}]`,
+			`{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`,
+			`[{"op":"test","path":"/baz","value":"qux"},
+			  {"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"/child","value":{"grandchild":{}}}]`,
+			`{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz","value":"qux","xyz":123}]`,
+			`{"foo":"bar","baz":"qux"}`},
+		{`{"/":9,"~1":10}`,
+			`[{"op":"test","path":"/~01","value":10}]`,
+			`{"/":9,"~1":10}`},
+		{`{"foo":["bar"]}`,
+			`[{"op":"add","path":"/foo/-","value":["abc","def"]}]`,
+			`{"foo":["bar",["abc","def"]]}`},
+		// additional cases
+		{`{"foo":"bar"}`,
+			`[{"op":"copy","from":"/foo","path":"/baz"}]`,
+			`{"foo":"bar","baz":"bar"}`},
+		{`{"a.b":{"1":1}}`,
+			`[{"op":"add","path":"/a.b/2","value":2}]`,
+			`{"a.b":{"1":1,"2":2}}`},
+		{`{"foo":"bar"}`,
+			`[{"op":"replace","path":"","value":[1,2]}]`,
+			`[1,2]`},
+		{`[1,2]`,
+			`[{"op":"add","path":"/0","value":0}]`,
+			`[0,1,2]`},
+		{`{"a":{"b":[1,2]}}`,
+			`[{"op":"test","path":"/a","value":{"b":[1.0, 2]}}]`,
+			`{"a":{"b":[1,2]}}`},
+	}
+	for i, tt := range tests {
+		res, err := ApplyPatch(tt.json, tt.patch)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if sortJSON(res) != sortJSON(tt.expect) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := ApplyPatchBytes([]byte(tt.json), []byte(tt.patch))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(bres) != res {
+			t.Fatalf("%d: expected '%v', got '%v'", i, res, string(bres))
+		}
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		json, patch, errmsg string
+	}{
+		{`{"baz":"qux"}`,
+			`[{"op":"test","path":"/baz","value":"bar"}]`,
+			"patch operation 0: test failed"},
+		{`{"foo":"bar"}`,
+			`[{"op":"add","path":"/baz/bat","value":"qux"}]`,
+			"patch operation 0: path '/baz/bat' not found"},
+		{`{"foo":[1]}`,
+			`[{"op":"remove","path":"/foo"},{"op":"remove","path":"/foo/3"}]`,
+			"patch operation 1: "},
+		{`{"foo":[1]}`,
+			`[{"op":"remove","path":"/foo/3"}]`,
+			"patch operation 0: path '/foo/3' not found"},
+		{`{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/5","value":1}]`,
+			"patch operation 0: index out of range"},
+		{`{"foo":[1]}`,
+			`[{"op":"add","path":"/foo/01","value":1}]`,
+			"patch operation 0: invalid path"},
+		{`{"foo":{}}`,
+			`[{"op":"move","from":"/foo","path":"/foo/bar"}]`,
+			"patch operation 0: cannot move"},
+		{`{}`, `[{"op":"add","path":"/a"}]`, "patch operation 0: missing value"},
+		{`{}`, `[{"op":"nope","path":"/a"}]`, "patch operation 0: unknown"},
+		{`{}`, `{"op":"add"}`, "patch must be an array"},
+		{`{}`, `[`, "invalid patch"},
+	}
+	for i, tt := range tests {
+		res, err := ApplyPatch(tt.json, tt.patch)
+		if err == nil || !strings.HasPrefix(err.Error(), tt.errmsg) {
+			t.Fatalf("%d: expected error '%v', got '%v'", i, tt.errmsg, err)
+		}
+		if res != tt.json {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.json, res)
+		}
+	}
+}
+
+func TestSetDiff(t *testing.T) {
+	tests := []struct {
+		json, path string
+		value      interface{}
+		expect     string
+	}{
+		{`{"a":1}`, "a", 2, `{"op":"replace","path":"/a","value":2}`},
+		{`{"a":1}`, "b", "x", `{"op":"add","path":"/b","value":"x"}`},
+		{`{"a":1}`, "b.c.d", true,
+			`{"op":"add","path":"/b","value":{"c":{"d":true}}}`},
+		{`{"a":{"b":[1,2]}}`, "a.b.1", 3,
+			`{"op":"replace","path":"/a/b/1","value":3}`},
+		{`{"a":{"b":[1,2]}}`, "a.b.-1", 3,
+			`{"op":"add","path":"/a/b/2","value":3}`},
+		{`{"a":{"b":[1,2]}}`, "a.b.2", 3,
+			`{"op":"add","path":"/a/b/2","value":3}`},
+		{`{"a":{"b":[1,2]}}`, "a.b.4", 3,
+			`{"op":"replace","path":"/a/b","value":[1,2,null,null,3]}`},
+		{`{"a":{"b":[1,2]}}`, "a.b.5.c", 3, `{"op":"replace","path":"/a/b",` +
+			`"value":[1,2,null,null,null,{"c":3}]}`},
+		{`{"a":"x"}`, "a.b", 3, `{"op":"replace","path":"/a","value":{"b":3}}`},
+		{`{"a/b":{"~":1}}`, `a/b.~`, 2,
+			`{"op":"replace","path":"/a~1b/~0","value":2}`},
+		{``, "a", 1, `{"op":"add","path":"","value":{"a":1}}`},
+		{`{"1":0}`, "1", 1, `{"op":"replace","path":"/1","value":1}`},
+	}
+	for i, tt := range tests {
+		res, op, err := SetDiff(tt.json, tt.path, tt.value)
+		if err != nil || op.String() != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, op,
+				err)
+		}
+		patched, err := ApplyPatch(tt.json, "["+op.String()+"]")
+		if err != nil || !jsonEqual(gjson.Parse(patched), gjson.Parse(res)) {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, res, patched,
+				err)
+		}
+	}
+	if _, _, err := SetDiff(`{}`, "a.#", 1); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidPath, err)
+	}
+}
@@ -0,0 +1,262 @@
+package sjson
+
+import "unsafe"
+
+// Path is a compiled path which can be reused for setting and deleting
+// values in many json documents without parsing the path string each time.
+// A Path is safe for concurrent use by multiple goroutines.
+type Path struct {
+	path   string
+	paths  []pathResult
+	simple bool
+}
+
+// CompilePath parses the path and returns a Path that can be used for
+// setting and deleting values. An error is returned when the path is empty,
+// ends with an incomplete escape character, or contains an unbalanced query.
+func CompilePath(path string) (*Path, error) {
+	if path == "" {
+		return nil, ErrEmptyPath
+	}
+	if !validPath(path) {
+		return nil, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	p := &Path{path: path}
+	p.paths, p.simple = splitPath(path)
+	return p, nil
+}
+
+// validPath checks the escape characters and the nesting of queries in the
+// path.
+func validPath(path string) bool {
+	var depth int
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+			if i == len(path) {
+				return false
+			}
+		case '(':
+			if depth > 0 || (i > 0 && path[i-1] == '#') {
+				depth++
+			}
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case '"':
+			if depth == 0 {
+				continue
+			}
+			// skip over string literal in a query
+			for i++; i < len(path) && path[i] != '"'; i++ {
+				if path[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(path) {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// String returns the path that was compiled.
+func (p *Path) String() string {
+	return p.path
+}
+
+// Set sets a json value for the compiled path.
+func (p *Path) Set(json string, value interface{}) (string, error) {
+	jsonh := *(*stringHeader)(unsafe.Pointer(&json))
+	jsonbh := sliceHeader{data: jsonh.data, len: jsonh.len, cap: jsonh.len}
+	jsonb := *(*[]byte)(unsafe.Pointer(&jsonbh))
+	res, err := p.SetBytes(jsonb, value)
+	return string(res), err
+}
+
+// SetBytes sets a json value for the compiled path.
+// If working with bytes, this method preferred over
+// p.Set(string(data), value)
+func (p *Path) SetBytes(json []byte, value interface{}) ([]byte, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return nil, &PathError{Path: p.path, Offset: -1, Err: err}
+	}
+	return p.edit(json, raw, stringify, del)
+}
+
+// Delete deletes a value from json for the compiled path.
+func (p *Path) Delete(json string) (string, error) {
+	res, err := p.DeleteBytes([]byte(json))
+	return string(res), err
+}
+
+// DeleteBytes deletes a value from json for the compiled path.
+func (p *Path) DeleteBytes(json []byte) ([]byte, error) {
+	return p.edit(json, "", false, true)
+}
+
+func (p *Path) edit(json []byte, raw string, stringify, del bool) ([]byte,
+	error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	e, err := pathsEdit(jstr, p.path, p.paths, p.simple, raw, stringify, del,
+		nil)
+	if err == errNoChange {
+		return json, nil
+	}
+	if err != nil {
+		return json, err
+	}
+	return e.append(make([]byte, 0, e.size(jstr)), jstr), nil
+}
+
+// PathComponentKind is the kind of a path component.
+type PathComponentKind int
+
+const (
+	// PathKey is an object key.
+	PathKey PathComponentKind = iota
+	// PathIndex is an array index, such as "2". On an object it's used as a
+	// key, unless the key is forced with a ':' prefix, such as ":2".
+	PathIndex
+	// PathAppend is the "-1" marker that appends to an array.
+	PathAppend
+	// PathAll is the "#" component that refers to every element of an
+	// array, such as "friends.#.last".
+	PathAll
+	// PathQuery is a query that refers to the first matching element of an
+	// array, such as `#(last="Murphy")`.
+	PathQuery
+	// PathQueryAll is a query that refers to every matching element of an
+	// array, such as `#(last="Murphy")#`.
+	PathQueryAll
+	// PathWildcard is a key pattern that has the '*' or '?' wildcard
+	// characters, such as "user*".
+	PathWildcard
+)
+
+// PathComponent is a component of a path returned by ParsePath.
+type PathComponent struct {
+	Kind PathComponentKind
+	// Key is the unescaped object key for PathKey and PathIndex, and the
+	// component as it's written in the path for the other kinds.
+	Key string
+	// Index is the array index for PathIndex.
+	Index int
+}
+
+// String returns the component as it's written in a path, escaping the key
+// where needed.
+func (c PathComponent) String() string {
+	switch c.Kind {
+	case PathKey:
+		return escapeKey(c.Key)
+	case PathAppend:
+		return "-1"
+	case PathAll:
+		return "#"
+	}
+	return c.Key
+}
+
+// ParsePath splits a path into its components in the same way as the set
+// and delete functions, following the escaping rules for the '\' and ':'
+// characters. Joining the String of each component with a '.' returns an
+// equivalent path. An error is returned when the path is empty or not
+// valid, or when it has a modifier or a '|' pipe, which cannot be used for
+// setting values.
+func ParsePath(path string) ([]PathComponent, error) {
+	if path == "" {
+		return nil, ErrEmptyPath
+	}
+	if !validPath(path) {
+		return nil, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	var comps []PathComponent
+	for i := 0; ; i++ {
+		c, n, ok := parseComponent(path[i:])
+		if !ok {
+			return nil, &PathError{Path: path, Offset: -1,
+				Err: ErrInvalidPath}
+		}
+		comps = append(comps, c)
+		i += n
+		if i == len(path) {
+			return comps, nil
+		}
+	}
+}
+
+// parseComponent parses the first component of the path and returns the
+// number of bytes that it used, which excludes the following '.'.
+func parseComponent(path string) (c PathComponent, n int, ok bool) {
+	if len(path) > 1 && path[0] == '#' && path[1] == '(' {
+		// find the closing parenthesis, skipping over string literals
+		depth := 0
+	scan:
+		for ; n < len(path); n++ {
+			switch path[n] {
+			case '(':
+				depth++
+			case ')':
+				if depth--; depth == 0 {
+					break scan
+				}
+			case '"':
+				for n++; n < len(path) && path[n] != '"'; n++ {
+					if path[n] == '\\' {
+						n++
+					}
+				}
+			}
+		}
+		n++
+		c.Kind = PathQuery
+		if n < len(path) && path[n] == '#' {
+			c.Kind = PathQueryAll
+			n++
+		}
+		c.Key = path[:n]
+		return c, n, n == len(path) || path[n] == '.'
+	}
+	var force, escaped, wild bool
+	var key []byte
+	if len(path) > 0 && path[0] == ':' {
+		force = true
+		n++
+	}
+	for ; n < len(path) && path[n] != '.'; n++ {
+		switch path[n] {
+		case '\\':
+			escaped = true
+			n++
+		case '*', '?':
+			wild = true
+		case '|':
+			return c, n, false
+		case '@':
+			if len(key) == 0 && !force {
+				// a modifier
+				return c, n, false
+			}
+		}
+		key = append(key, path[n])
+	}
+	switch {
+	case wild:
+		c.Kind, c.Key = PathWildcard, path[:n]
+	case !force && !escaped && string(key) == "#":
+		c.Kind, c.Key = PathAll, "#"
+	case !force && string(key) == "-1":
+		c.Kind, c.Key = PathAppend, "-1"
+	default:
+		c.Key = string(key)
+		if c.Index, ok = atoui(pathResult{part: c.Key, force: force}); ok {
+			c.Kind = PathIndex
+		}
+	}
+	return c, n, true
+}
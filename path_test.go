@@ -0,0 +1,150 @@
+package sjson
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompilePath(t *testing.T) {
+	tests := []struct {
+		json, path string
+		value      interface{}
+	}{
+		{`{"a":{"b":1}}`, "a.b", 2},
+		{`{"a":{"b":1}}`, "a.c.d", "hello"},
+		{`{"a":[1,2]}`, "a.-1", 3},
+		{`{"a":[1,2]}`, "a.5", 3},
+		{`{"a.b":1}`, `a\.b`, true},
+		{`{"1":1}`, ":1", nil},
+		{`{"a":[{"b":1},{"b":2}]}`, "a.#.b", 3},
+		{`{"a":[{"b":1},{"b":2}]}`, "a.#(b==2).b", 4},
+		{`{"a":1}`, "b", 1},
+	}
+	for i, tt := range tests {
+		p, err := CompilePath(tt.path)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		expect, _ := Set(tt.json, tt.path, tt.value)
+		res, err := p.Set(tt.json, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, res)
+		}
+		// compiled paths are reusable
+		res, _ = p.Set(tt.json, tt.value)
+		if res != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, res)
+		}
+		bres, _ := p.SetBytes([]byte(tt.json), tt.value)
+		if string(bres) != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, string(bres))
+		}
+		expect, experr := Delete(tt.json, tt.path)
+		res, err = p.Delete(tt.json)
+		if res != expect || (err == nil) != (experr == nil) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, res)
+		}
+	}
+}
+
+func TestCompilePathErrors(t *testing.T) {
+	if _, err := CompilePath(""); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+	for _, path := range []string{`a\`, `a.#(b==1`, `a.#(b=="1)`, `a.b\`} {
+		if _, err := CompilePath(path); !errors.Is(err, ErrInvalidPath) {
+			t.Fatalf("%s: expected '%v', got '%v'", path, ErrInvalidPath, err)
+		}
+	}
+	for _, path := range []string{`a\\`, `a.#(b=="(")`, `a.(b)`, `a.#(b=="\"")#`} {
+		if _, err := CompilePath(path); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path  string
+		comps []PathComponent
+	}{
+		{"name.last", []PathComponent{{PathKey, "name", 0},
+			{PathKey, "last", 0}}},
+		{`\:\\1.this.4.\.HI`, []PathComponent{{PathKey, `:\1`, 0},
+			{PathKey, "this", 0}, {PathIndex, "4", 4}, {PathKey, ".HI", 0}}},
+		{"a.:1.-1", []PathComponent{{PathKey, "a", 0}, {PathKey, "1", 0},
+			{PathAppend, "-1", 0}}},
+		{`a.:-1.\#`, []PathComponent{{PathKey, "a", 0}, {PathKey, "-1", 0},
+			{PathKey, "#", 0}}},
+		{"friends.#.last", []PathComponent{{PathKey, "friends", 0},
+			{PathAll, "#", 0}, {PathKey, "last", 0}}},
+		{`friends.#(name.last="Mur.phy")#.age`, []PathComponent{
+			{PathKey, "friends", 0}, {PathQueryAll, `#(name.last="Mur.phy")#`, 0},
+			{PathKey, "age", 0}}},
+		{`#(a==")").b`, []PathComponent{{PathQuery, `#(a==")")`, 0},
+			{PathKey, "b", 0}}},
+		{`user*.x\*`, []PathComponent{{PathWildcard, "user*", 0},
+			{PathKey, "x*", 0}}},
+		{"a.", []PathComponent{{PathKey, "a", 0}, {PathIndex, "", 0}}},
+		{`e\@x.a@b`, []PathComponent{{PathKey, "e@x", 0}, {PathKey, "a@b", 0}}},
+	}
+	for i, tt := range tests {
+		comps, err := ParsePath(tt.path)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if len(comps) != len(tt.comps) {
+			t.Fatalf("%d: expected %v, got %v", i, tt.comps, comps)
+		}
+		for j := range comps {
+			if comps[j] != tt.comps[j] {
+				t.Fatalf("%d: expected %v, got %v", i, tt.comps, comps)
+			}
+		}
+		strs := make([]string, len(comps))
+		for j, c := range comps {
+			strs[j] = c.String()
+		}
+		// the joined components are an equivalent path
+		path := strings.Join(strs, ".")
+		again, err := ParsePath(path)
+		if err != nil || len(again) != len(comps) {
+			t.Fatalf("%d: expected %v, got %v", i, comps, again)
+		}
+		for j := range again {
+			if again[j] != comps[j] {
+				t.Fatalf("%d: expected %v, got %v", i, comps, again)
+			}
+		}
+		// the components agree with the set functions
+		if paths, simple := splitPath(tt.path); simple {
+			for j, r := range paths {
+				if r.part != comps[j].Key {
+					t.Fatalf("%d: expected '%v', got '%v'", i, r.part,
+						comps[j].Key)
+				}
+			}
+		}
+	}
+	comps, _ := ParsePath(`\:\\1.this.4.\.HI`)
+	var path string
+	for i, c := range comps {
+		if i > 0 {
+			path += "."
+		}
+		path += c.String()
+	}
+	if path != `\:\\1.this.4.\.HI` {
+		t.Fatalf("expected '%v', got '%v'", `\:\\1.this.4.\.HI`, path)
+	}
+	for _, path := range []string{"", `a\`, "a.@reverse", "a|b", "#(a==1)x",
+		`a.#(b=="1)`} {
+		if _, err := ParsePath(path); err == nil {
+			t.Fatalf("%s: expected an error", path)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package sjson
+
+import jsongo "encoding/json"
+
+// SetRawMessage sets a json value for the specified path in a
+// json.RawMessage. This is the same as SetBytes, for documents that are
+// held in json.RawMessage fields.
+func SetRawMessage(json jsongo.RawMessage, path string,
+	value interface{}) (jsongo.RawMessage, error) {
+	return SetBytesOptions(json, path, value, nil)
+}
+
+// SetRawMessageOptions sets a json value for the specified path in a
+// json.RawMessage with options.
+func SetRawMessageOptions(json jsongo.RawMessage, path string,
+	value interface{}, opts *Options) (jsongo.RawMessage, error) {
+	return SetBytesOptions(json, path, value, opts)
+}
+
+// SetRawMessageRaw sets a raw json value for the specified path in a
+// json.RawMessage. The value is inserted as is.
+func SetRawMessageRaw(json jsongo.RawMessage, path string,
+	value jsongo.RawMessage) (jsongo.RawMessage, error) {
+	return SetRawBytesOptions(json, path, value, nil)
+}
+
+// SetRawMessageRawOptions sets a raw json value for the specified path in a
+// json.RawMessage with options.
+func SetRawMessageRawOptions(json jsongo.RawMessage, path string,
+	value jsongo.RawMessage, opts *Options) (jsongo.RawMessage, error) {
+	return SetRawBytesOptions(json, path, value, opts)
+}
+
+// DeleteRawMessage deletes a value for the specified path from a
+// json.RawMessage.
+func DeleteRawMessage(json jsongo.RawMessage, path string) (jsongo.RawMessage,
+	error) {
+	return DeleteBytesOptions(json, path, nil)
+}
+
+// DeleteRawMessageOptions deletes a value for the specified path from a
+// json.RawMessage with options.
+func DeleteRawMessageOptions(json jsongo.RawMessage, path string,
+	opts *Options) (jsongo.RawMessage, error) {
+	return DeleteBytesOptions(json, path, opts)
+}
@@ -0,0 +1,45 @@
+package sjson
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetRawMessage(t *testing.T) {
+	var doc struct {
+		Name  string          `json:"name"`
+		Attrs json.RawMessage `json:"attrs"`
+	}
+	if err := json.Unmarshal([]byte(`{"name":"x","attrs":{"a":1}}`),
+		&doc); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if doc.Attrs, err = SetRawMessage(doc.Attrs, "b", "two"); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Attrs, err = SetRawMessageRaw(doc.Attrs, "c",
+		json.RawMessage(`[true]`)); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Attrs, err = DeleteRawMessage(doc.Attrs, "a"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(doc)
+	if err != nil || string(b) != `{"name":"x","attrs":{"b":"two","c":[true]}}` {
+		t.Fatalf("unexpected '%s' (%v)", b, err)
+	}
+	opts := &Options{Pretty: true, Indent: " "}
+	res, err := SetRawMessageOptions(json.RawMessage(`{}`), "a", 1, opts)
+	if err != nil || string(res) != "{\n \"a\": 1\n}\n" {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+	res, err = SetRawMessageRawOptions(res, "b", json.RawMessage(`2`), opts)
+	if err != nil || string(res) != "{\n \"a\": 1,\n \"b\": 2\n}\n" {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+	res, err = DeleteRawMessageOptions(res, "a", opts)
+	if err != nil || string(res) != "{\n \"b\": 2\n}\n" {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+}
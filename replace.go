@@ -0,0 +1,188 @@
+package sjson
+
+import (
+	"strconv"
+
+	"github.com/tidwall/gjson"
+)
+
+// ReplaceOptions represents additional options for the ReplaceValue
+// function.
+type ReplaceOptions struct {
+	// Types restricts the replaced values to the listed types, such as
+	// gjson.String for strings only, and lets them match old across types
+	// by its text. For example, with gjson.String and gjson.Number, an old
+	// value of 5 replaces both 5 and "5". When empty, the values of any type
+	// are replaced when they are equal to old, with the same type.
+	Types []gjson.Type
+}
+
+// ReplaceValue replaces every scalar value that is equal to old with new,
+// anywhere in the json document. The old and new values are converted in the
+// same way as the value of Set, and numbers are compared by value. Only the
+// replaced values change, all other bytes are preserved.
+func ReplaceValue(json string, old, new interface{}) (string, error) {
+	return ReplaceValueOptions(json, old, new, nil)
+}
+
+// ReplaceValueOptions replaces every scalar value that is equal to old with
+// new, with options.
+func ReplaceValueOptions(json string, old, new interface{},
+	opts *ReplaceOptions) (string, error) {
+	oraw, ostringify, _, err := valueRaw(old, nil)
+	if err != nil {
+		return json, err
+	}
+	nraw, nstringify, _, err := valueRaw(new, nil)
+	if err != nil {
+		return json, err
+	}
+	var match gjson.Result
+	if ostringify {
+		match = gjson.Result{Type: gjson.String, Str: oraw}
+	} else {
+		match = gjson.Parse(oraw)
+	}
+	if match.Type == gjson.JSON {
+		return json, nil
+	}
+	equal := func(value gjson.Result) bool { return jsonEqual(value, match) }
+	if opts != nil && len(opts.Types) > 0 {
+		text := match.Raw
+		if match.Type == gjson.String {
+			text = match.Str
+		}
+		equal = func(value gjson.Result) bool {
+			var ok bool
+			for _, typ := range opts.Types {
+				ok = ok || typ == value.Type
+			}
+			switch {
+			case !ok:
+				return false
+			case value.Type == gjson.String:
+				return value.Str == text
+			case value.Type == gjson.Number:
+				return validNumber(text) && gjson.Parse(text).Num == value.Num
+			default:
+				return value.Raw == text
+			}
+		}
+	}
+	root := gjson.Parse(json)
+	for ; root.Index < len(json); root.Index++ {
+		if json[root.Index] > ' ' {
+			break
+		}
+	}
+	// the indexes of the matched values, in order
+	var starts, ends []int
+	var walk func(value gjson.Result)
+	walk = func(value gjson.Result) {
+		if value.Type == gjson.JSON {
+			value.ForEach(func(_, value gjson.Result) bool {
+				walk(value)
+				return true
+			})
+		} else if equal(value) {
+			starts = append(starts, value.Index)
+			ends = append(ends, value.Index+len(value.Raw))
+		}
+	}
+	walk(root)
+	if len(starts) == 0 {
+		return json, nil
+	}
+	var mid []byte
+	if nstringify {
+		mid = appendStringify(mid, nraw)
+	} else {
+		mid = append(mid, nraw...)
+	}
+	buf := make([]byte, 0, len(json)+len(starts)*len(mid))
+	var end int
+	for i := range starts {
+		buf = append(buf, json[end:starts[i]]...)
+		buf = append(buf, mid...)
+		end = ends[i]
+	}
+	buf = append(buf, json[end:]...)
+	return string(buf), nil
+}
+
+// MapLeaves calls fn for every scalar value in the json document, with the
+// path of the value. When fn returns true the value is replaced with the
+// returned value, which is converted in the same way as the value of Set.
+// Empty objects and arrays, and a document that is a single scalar value,
+// are not visited. All replacements are made in a single pass over the
+// document, and other bytes are preserved.
+func MapLeaves(json string, fn func(path string, value gjson.Result) (
+	interface{}, bool)) (string, error) {
+	root := gjson.Parse(json)
+	for ; root.Index < len(json); root.Index++ {
+		if json[root.Index] > ' ' {
+			break
+		}
+	}
+	// the indexes and replacements of the mapped values, in order
+	var starts, ends []int
+	var mids []byte
+	var mends []int
+	var err error
+	var walk func(path string, value gjson.Result) bool
+	walk = func(path string, value gjson.Result) bool {
+		if value.Type == gjson.JSON {
+			var i int
+			value.ForEach(func(key, value gjson.Result) bool {
+				var part string
+				if key.Type == gjson.String {
+					part = escapeKey(key.Str)
+				} else {
+					part = strconv.Itoa(i)
+				}
+				i++
+				return walk(joinPath(path, part), value)
+			})
+			return err == nil
+		}
+		nvalue, ok := fn(path, value)
+		if !ok {
+			return true
+		}
+		raw, stringify, del, verr := valueRaw(nvalue, nil)
+		if verr == nil && del {
+			verr = &errorType{"leaf values cannot be deleted"}
+		}
+		if verr != nil {
+			err = &PathError{Path: path, Offset: value.Index, Err: verr}
+			return false
+		}
+		if stringify {
+			mids = appendStringify(mids, raw)
+		} else {
+			mids = append(mids, raw...)
+		}
+		starts = append(starts, value.Index)
+		ends = append(ends, value.Index+len(value.Raw))
+		mends = append(mends, len(mids))
+		return true
+	}
+	if root.Type == gjson.JSON {
+		walk("", root)
+	}
+	if err != nil {
+		return json, err
+	}
+	if len(starts) == 0 {
+		return json, nil
+	}
+	buf := make([]byte, 0, len(json)+len(mids))
+	var end, mstart int
+	for i := range starts {
+		buf = append(buf, json[end:starts[i]]...)
+		buf = append(buf, mids[mstart:mends[i]]...)
+		end, mstart = ends[i], mends[i]
+	}
+	buf = append(buf, json[end:]...)
+	return string(buf), nil
+}
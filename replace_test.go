@@ -0,0 +1,98 @@
+package sjson
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+func TestReplaceValue(t *testing.T) {
+	tests := []struct {
+		json     string
+		old, new interface{}
+		types    []gjson.Type
+		expect   string
+	}{
+		{`{"a":"REDACT_ME","b":["REDACT_ME", {"c" : "REDACT_ME"}],"d":"x"}`,
+			"REDACT_ME", "***", nil,
+			`{"a":"***","b":["***", {"c" : "***"}],"d":"x"}`},
+		{`{"a":1,"b":1.0,"c":"1","d":[1e0]}`, 1, 2, nil,
+			`{"a":2,"b":2,"c":"1","d":[2]}`},
+		{`{"a":1,"c":"1"}`, "1", nil, nil, `{"a":1,"c":null}`},
+		{`{"a":null,"b":[null]}`, nil, false, nil, `{"a":false,"b":[false]}`},
+		{`{"a":"x","b":"x"}`, "x", `q"uote`, nil,
+			`{"a":"q\"uote","b":"q\"uote"}`},
+		{`{"a":1}`, 1, 2, []gjson.Type{gjson.String}, `{"a":1}`},
+		{`{"a":1}`, 1, 2, []gjson.Type{gjson.String, gjson.Number},
+			`{"a":2}`},
+		{`{"a":1,"b":"1","c":"x"}`, 1, 2, []gjson.Type{gjson.String},
+			`{"a":1,"b":2,"c":"x"}`},
+		{`{"a":1.0,"b":"1","c":[1e0]}`, "1", 0,
+			[]gjson.Type{gjson.String, gjson.Number}, `{"a":0,"b":0,"c":[0]}`},
+		{`{"a":1,"b":"1"}`, "1", 0, []gjson.Type{gjson.Number},
+			`{"a":0,"b":"1"}`},
+		{`{"a":true,"b":"true"}`, "true", false, []gjson.Type{gjson.True},
+			`{"a":false,"b":"true"}`},
+		{`{"a":null,"b":"null"}`, nil, 0, []gjson.Type{gjson.String},
+			`{"a":null,"b":0}`},
+		{`{"a":{"b":1}}`, map[string]int{"b": 1}, 2, nil, `{"a":{"b":1}}`},
+		{` "x" `, "x", "y", nil, ` "y" `},
+		{`{"x":"y"}`, "x", "z", nil, `{"x":"y"}`},
+	}
+	for i, tt := range tests {
+		res, err := ReplaceValueOptions(tt.json, tt.old, tt.new,
+			&ReplaceOptions{Types: tt.types})
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+}
+
+func TestMapLeaves(t *testing.T) {
+	json := `{"name":"  alice ","scores":[1.4, 2.6],"tags":{"a.b":" x"},` +
+		`"empty":[],"ok":true}`
+	var paths []string
+	res, err := MapLeaves(json, func(path string, value gjson.Result) (
+		interface{}, bool) {
+		paths = append(paths, path)
+		switch value.Type {
+		case gjson.String:
+			return strings.TrimSpace(value.Str), true
+		case gjson.Number:
+			return math.Round(value.Num), true
+		}
+		return nil, false
+	})
+	expect := `{"name":"alice","scores":[1, 3],"tags":{"a.b":"x"},` +
+		`"empty":[],"ok":true}`
+	if err != nil || res != expect {
+		t.Fatalf("expected '%v', got '%v' (%v)", expect, res, err)
+	}
+	if strings.Join(paths, ",") != `name,scores.0,scores.1,tags.a\.b,ok` {
+		t.Fatalf("unexpected paths %v", paths)
+	}
+	for _, path := range paths {
+		if !getPath(json, path).Exists() {
+			t.Fatalf("path %v does not exist", path)
+		}
+	}
+	_, err = MapLeaves(json, func(path string, value gjson.Result) (
+		interface{}, bool) {
+		return func() {}, path == "ok"
+	})
+	var perr *PathError
+	if !errors.As(err, &perr) || perr.Path != "ok" {
+		t.Fatalf("unexpected %v", err)
+	}
+	res, err = MapLeaves(` 1 `, func(string, gjson.Result) (interface{},
+		bool) {
+		return 2, true
+	})
+	if err != nil || res != ` 1 ` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+}
@@ -0,0 +1,99 @@
+package sjson
+
+import (
+	"bytes"
+	"io"
+	"unsafe"
+)
+
+// SharedResult is the result of SetBytesShared. The document is the
+// Prefix, Mid, and Suffix joined together, where only Mid is newly
+// allocated. The Prefix and Suffix are the unchanged bytes before and after
+// the edit, and they share the backing array of the input json.
+//
+// The input json must not be modified while the result is in use, and
+// modifying the Prefix or Suffix modifies the input json. Use Bytes for a
+// copy of the document that does not share any bytes.
+type SharedResult struct {
+	Prefix []byte
+	Mid    []byte
+	Suffix []byte
+}
+
+// Len returns the length of the document.
+func (r SharedResult) Len() int {
+	return len(r.Prefix) + len(r.Mid) + len(r.Suffix)
+}
+
+// Bytes returns a copy of the document.
+func (r SharedResult) Bytes() []byte {
+	buf := make([]byte, 0, r.Len())
+	buf = append(buf, r.Prefix...)
+	buf = append(buf, r.Mid...)
+	return append(buf, r.Suffix...)
+}
+
+// WriteTo writes the document to w, without joining its parts.
+func (r SharedResult) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for _, part := range [][]byte{r.Prefix, r.Mid, r.Suffix} {
+		if len(part) == 0 {
+			continue
+		}
+		n, err := w.Write(part)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Reader returns a reader of the document, without joining its parts.
+func (r SharedResult) Reader() io.Reader {
+	return io.MultiReader(bytes.NewReader(r.Prefix), bytes.NewReader(r.Mid),
+		bytes.NewReader(r.Suffix))
+}
+
+// SetBytesShared sets a json value for the specified path, returning a
+// result that shares the unchanged bytes of json rather than copying them.
+// This avoids copying a large document for a small edit, such as when it's
+// written to a file or a network connection with WriteTo.
+func SetBytesShared(json []byte, path string, value interface{}) (
+	SharedResult, error) {
+	return SetBytesSharedOptions(json, path, value, nil)
+}
+
+// SetBytesSharedOptions sets a json value for the specified path with
+// options, returning a result that shares the unchanged bytes of json. The
+// ReplaceInPlace option is ignored, and the json is never modified. When an
+// option reformats the document, such as Pretty, the whole document is in
+// the Mid of the result, even when nothing was set.
+func SetBytesSharedOptions(json []byte, path string, value interface{},
+	opts *Options) (SharedResult, error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return SharedResult{}, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := setEdit(jstr, path, raw, stringify, del, opts)
+	if err == errNoChange {
+		if reformats(opts) {
+			res := append([]byte(nil), json...)
+			return SharedResult{Mid: formatResult(res, opts)}, nil
+		}
+		return SharedResult{Prefix: json}, nil
+	}
+	if err != nil {
+		return SharedResult{}, err
+	}
+	if reformats(opts) {
+		res := e.append(make([]byte, 0, e.size(jstr)), jstr)
+		return SharedResult{Mid: formatResult(res, opts)}, nil
+	}
+	return SharedResult{
+		Prefix: json[e.lead:e.start:e.start],
+		Mid:    e.appendMid(nil),
+		Suffix: json[e.end:],
+	}, nil
+}
@@ -0,0 +1,75 @@
+package sjson
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestSetBytesShared(t *testing.T) {
+	json := []byte(`{"a":1,"b":[1,2],"c":"` + strings.Repeat("x", 1000) + `"}`)
+	orig := string(json)
+	tests := []struct {
+		path  string
+		value interface{}
+	}{
+		{"a", 2},
+		{"a", "two"},
+		{"b.-1", 3},
+		{"d", true},
+		{"b", dtype{}},
+		{"x", dtype{}},
+	}
+	for i, tt := range tests {
+		expect, err := SetBytes(json, tt.path, tt.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := SetBytesShared(json, tt.path, tt.value)
+		if err != nil || string(res.Bytes()) != string(expect) ||
+			res.Len() != len(expect) {
+			t.Fatalf("%d: expected '%s', got '%s' (%v)", i, expect, res.Bytes(),
+				err)
+		}
+		var buf bytes.Buffer
+		if n, err := res.WriteTo(&buf); err != nil || n != int64(len(expect)) ||
+			buf.String() != string(expect) {
+			t.Fatalf("%d: unexpected '%s' (%v)", i, buf.Bytes(), err)
+		}
+		b, err := ioutil.ReadAll(res.Reader())
+		if err != nil || string(b) != string(expect) {
+			t.Fatalf("%d: unexpected '%s' (%v)", i, b, err)
+		}
+		if string(json) != orig {
+			t.Fatalf("%d: json was modified", i)
+		}
+	}
+	res, err := SetBytesShared(json, "a", 2)
+	if err != nil || &res.Prefix[0] != &json[0] || len(res.Suffix) < 1000 ||
+		&res.Suffix[0] != &json[6] {
+		t.Fatal("expected the suffix to share the json")
+	}
+	res, err = SetBytesSharedOptions([]byte(`{"a":1}`), "b", 2,
+		&Options{Pretty: true})
+	if err != nil || res.Prefix != nil || string(res.Bytes()) !=
+		"{\n  \"a\": 1,\n  \"b\": 2\n}\n" {
+		t.Fatalf("unexpected '%s' (%v)", res.Bytes(), err)
+	}
+	in := []byte(`{"a":1}`)
+	res, err = SetBytesSharedOptions(in, "x", dtype{}, &Options{Pretty: true})
+	if err != nil || res.Prefix != nil || string(res.Bytes()) !=
+		"{\n  \"a\": 1\n}\n" {
+		t.Fatalf("unexpected '%s' (%v)", res.Bytes(), err)
+	}
+	res, err = SetBytesSharedOptions(in, "x", dtype{},
+		&Options{NormalizeNumbers: true})
+	if err != nil || res.Prefix != nil || string(res.Bytes()) != `{"a":1}` ||
+		&res.Mid[0] == &in[0] {
+		t.Fatalf("unexpected '%s' (%v)", res.Bytes(), err)
+	}
+	if _, err := SetBytesShared(json, "", 1); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
@@ -2,13 +2,21 @@
 package sjson
 
 import (
+	"context"
 	jsongo "encoding/json"
 	"fmt"
+	"math"
+	"math/big"
 	"sort"
 	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 	"unsafe"
 
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/match"
+	"github.com/tidwall/pretty"
 )
 
 type errorType struct {
@@ -19,6 +27,73 @@ func (err *errorType) Error() string {
 	return err.msg
 }
 
+var (
+	// ErrEmptyPath is returned when the path is empty.
+	ErrEmptyPath = &errorType{"path cannot be empty"}
+	// ErrInvalidPath is returned when the path cannot be used for the
+	// operation, such as a non-numeric key on an array or deleting from a
+	// complex path.
+	ErrInvalidPath = &errorType{"invalid path"}
+	// ErrNotAnArray is returned when the operation requires an array but
+	// the path refers to another type of value.
+	ErrNotAnArray = &errorType{"not an array"}
+	// ErrIndexOutOfRange is returned when an array index is outside of the
+	// bounds of the array.
+	ErrIndexOutOfRange = &errorType{"index out of range"}
+	// ErrPathNotFound is returned when the operation requires an existing
+	// value but the path does not exist.
+	ErrPathNotFound = &errorType{"path not found"}
+	// ErrNotANumber is returned when the operation requires a number but
+	// the path refers to another type of value.
+	ErrNotANumber = &errorType{"not a number"}
+	// ErrInvalidJSON is returned when the json document cannot be edited,
+	// such as when it is not an object or array.
+	ErrInvalidJSON = &errorType{"invalid json"}
+	// ErrArrayGrowth is returned when padding an array with nulls up to an
+	// index would insert more elements than the MaxArrayGrowth option
+	// allows.
+	ErrArrayGrowth = &errorType{"array growth limit exceeded"}
+	// ErrKeyExists is returned when an object already has the key that the
+	// operation would add.
+	ErrKeyExists = &errorType{"key already exists"}
+	// ErrNotABool is returned when the operation requires a boolean but
+	// the path refers to another type of value.
+	ErrNotABool = &errorType{"not a boolean"}
+	// ErrNotAString is returned when the operation requires a string but
+	// the path refers to another type of value.
+	ErrNotAString = &errorType{"not a string"}
+	// ErrInputTooLarge is returned when the json document is larger than
+	// the MaxInputBytes option allows.
+	ErrInputTooLarge = &errorType{"input too large"}
+	// ErrNotAContainer is returned when the path goes through a value that
+	// is not an object or array, and the StrictContainers option is set.
+	ErrNotAContainer = &errorType{"not a container"}
+	// ErrMaxDepth is returned when setting a path would create a value
+	// nested deeper than the MaxDepth option allows.
+	ErrMaxDepth = &errorType{"maximum depth exceeded"}
+	// ErrUnsupportedExpression is returned when a JSONPath expression uses
+	// a feature that SetJSONPath does not support, such as a filter.
+	ErrUnsupportedExpression = &errorType{"unsupported expression"}
+)
+
+// PathError records an error and the path that caused it. The Err field is
+// one of the Err* values, or the error from encoding a value, and may be
+// checked with errors.Is.
+type PathError struct {
+	Path   string // the path passed to the function
+	Offset int    // byte offset in the json, or -1 if not applicable
+	Err    error  // the reason for the error
+}
+
+func (err *PathError) Error() string {
+	return err.Err.Error() + " at '" + err.Path + "'"
+}
+
+// Unwrap returns the underlying error.
+func (err *PathError) Unwrap() error {
+	return err.Err
+}
+
 // Options represents additional options for the Set and Delete functions.
 type Options struct {
 	// Optimistic is a hint that the value likely exists which
@@ -32,7 +107,132 @@ type Options struct {
 	// created under the hood.
 	// The Optimistic flag must be set to true and the input must be a
 	// byte slice in order to use this field.
+	// The caller must be the only owner of the input, which must not be
+	// read or written by other goroutines during the call, nor be shared
+	// with a string or a pooled buffer that's still in use elsewhere.
 	ReplaceInPlace bool
+	// Pretty formats the resulting json using indentation. This only
+	// applies to the final document and not to each individual edit.
+	Pretty bool
+	// Indent is the indentation used when Pretty is set. The default is
+	// two spaces.
+	Indent string
+	// Compact removes the insignificant whitespace from the resulting json,
+	// leaving the contents of strings unchanged. It's ignored when Pretty
+	// is set.
+	Compact bool
+	// SortKeys sorts the keys of every object in the resulting json, which
+	// is compacted unless Pretty is set. Duplicate keys are kept in their
+	// original order, and numbers keep their original text.
+	SortKeys bool
+	// PreserveIndent formats newly inserted values to match the existing
+	// indentation of the document, which keeps diffs of indented
+	// documents small. Documents that are on a single line are unaffected.
+	PreserveIndent bool
+	// PruneEmptyParents removes the parent containers of a deleted value
+	// that would be left empty, up to the first ancestor that has other
+	// members or elements. The root of the document is never removed.
+	PruneEmptyParents bool
+	// DisableAutoCreate stops Set from creating missing containers for the
+	// path, in which case ErrPathNotFound is returned. Only the last
+	// component of the path may be missing, which updates existing
+	// structure without adding new branches.
+	DisableAutoCreate bool
+	// MaxArrayGrowth is the maximum number of null elements that may be
+	// inserted when setting an index past the end of an array, such as
+	// "arr.1000000", in which case ErrArrayGrowth is returned instead. The
+	// limit applies to all of the arrays of the path together. The default
+	// of zero is unlimited.
+	MaxArrayGrowth int
+	// DuplicateKey selects the occurrence of a duplicated key that the last
+	// component of the path refers to, where 0 is the first occurrence and
+	// 1 is the second. Without this option the first occurrence is always
+	// used. When the key has fewer occurrences, Set returns ErrPathNotFound
+	// and Delete makes no change.
+	DuplicateKey int
+	// ValidateResult checks that the resulting json is valid before it's
+	// returned, such as after setting a raw value from an untrusted source.
+	// When it's not valid, ErrInvalidJSON is returned and the input json is
+	// left unchanged, even with ReplaceInPlace.
+	ValidateResult bool
+	// PreserveNumberText writes a gjson.Result number value with its
+	// original text, rather than formatting its float64 value, so that
+	// large integers and high precision decimals are not changed.
+	PreserveNumberText bool
+	// FloatPrecision is the number of digits used for float32 and float64
+	// values, as in strconv.FormatFloat. The default of zero, or -1, uses
+	// the shortest representation that round-trips, with or without
+	// FloatFormat.
+	FloatPrecision int
+	// FloatFormat is the format used for float32 and float64 values, which
+	// is 'f', 'e', or 'g'. The default is 'f'. Other formats return an
+	// error, as they are not valid json.
+	FloatFormat byte
+	// DisableHTMLEscape stops '<', '>', and '&' in string values from being
+	// escaped as \u003c, \u003e, and \u0026, like
+	// json.Encoder.SetEscapeHTML(false). Quotes, backslashes, and control
+	// characters are always escaped.
+	DisableHTMLEscape bool
+	// EscapeUnicode writes the non-ASCII characters of string values as
+	// \uXXXX escape sequences, using surrogate pairs for characters outside
+	// of the basic multilingual plane. Raw json is not changed.
+	EscapeUnicode bool
+	// IntegerFloats writes float32 and float64 values that are whole
+	// numbers as integers, such as 3 for float64(3), regardless of the
+	// FloatFormat and FloatPrecision options. Values of 1e21 and larger are
+	// formatted as other floats.
+	IntegerFloats bool
+	// MaxInputBytes is the maximum length of the json document that may be
+	// edited, in which case ErrInputTooLarge is returned before it's read.
+	// The default of zero is unlimited.
+	MaxInputBytes int
+	// NoNullPadding makes setting an index past the end of an array return
+	// ErrIndexOutOfRange, rather than padding the array with nulls up to the
+	// index. The index that's equal to the length of the array, or "-1",
+	// still appends an element.
+	NoNullPadding bool
+	// StripBOM removes a leading UTF-8 byte order mark from the document
+	// when it's edited. By default the mark is preserved. In both cases the
+	// mark is skipped when resolving the path.
+	StripBOM bool
+	// StrictContainers stops Set from replacing a string, number, boolean,
+	// or null with the object or array that the path goes into, in which
+	// case ErrNotAContainer is returned. By default such a value is
+	// replaced, so setting "a.b" on {"a":5} results in {"a":{"b":...}}.
+	StrictContainers bool
+	// ValueIsRaw makes SetOptions treat a string or []byte value as raw
+	// json, the same as SetRawOptions, rather than as a json string. Other
+	// types of values are not affected. Use ValidateResult to check the
+	// raw value.
+	ValueIsRaw bool
+	// PreserveTrailingNewline keeps the newline at the end of the json,
+	// either "\n" or "\r\n", at the end of the result. Otherwise an edit
+	// at the end of the document, such as adding a member to the root
+	// object, may remove it.
+	PreserveTrailingNewline bool
+	// MaxDepth is the maximum number of components of a path that creates
+	// a value, such as 3 for "a.b.0", in which case ErrMaxDepth is returned
+	// instead. Each component is one level of object or array nesting.
+	// Existing values at any depth may still be replaced or deleted. The
+	// default of zero is unlimited.
+	MaxDepth int
+	// StrictPathEscaping returns ErrInvalidPath for a backslash in the path
+	// that does not escape a punctuation character, such as '.' or ':',
+	// including a backslash at the end of the path. The error message has
+	// the index of the backslash in the path. By default such a backslash
+	// is tolerated.
+	StrictPathEscaping bool
+	// NormalizeNumbers rewrites every number of the result in its canonical
+	// form, such as 471 for "471.", 0.5 for "+.5", and 1e5 for "1E+05". The
+	// digits are kept, so the precision of a number is never changed, and
+	// strings are not touched.
+	NormalizeNumbers bool
+	// PostEditHook is called with the result of an edit before it's
+	// returned, such as to validate the result against a schema. When the
+	// hook returns an error, that error is returned and the input json is
+	// left unchanged, even with ReplaceInPlace. The hook is not called when
+	// nothing is changed, and it must not modify or keep the bytes.
+	PostEditHook func([]byte) error
 }
 
 type pathResult struct {
@@ -112,6 +312,56 @@ func parsePath(path string) (res pathResult, simple bool) {
 	return r, true
 }
 
+// isSafeKeyChar returns true if the key character does not need to be
+// escaped when used in a path.
+func isSafeKeyChar(c byte) bool {
+	return c <= ' ' || c > '~' || c == '_' || c == '-' || c == ':' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9')
+}
+
+// escapeKey returns a path component that always addresses key as a
+// literal object key.
+func escapeKey(key string) string {
+	var buf []byte
+	if _, numeric := atoui(pathResult{part: key}); numeric || key == "-1" {
+		// force a string key
+		buf = append(buf, ':')
+	} else if len(key) > 0 && key[0] == ':' {
+		buf = append(buf, '\\')
+	}
+	for i := 0; i < len(key); i++ {
+		if !isSafeKeyChar(key[i]) {
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, key[i])
+	}
+	return string(buf)
+}
+
+// getPath returns the value at the path, translating the sjson specific
+// path syntax, such as a forced ':' key, into a gjson path.
+func getPath(jstr, path string) gjson.Result {
+	return gjson.Get(jstr, gjsonPath(path))
+}
+
+// gjsonPath translates the sjson specific path syntax into a gjson path.
+func gjsonPath(path string) string {
+	r, simple := parsePath(path)
+	if !simple || (!r.more && !r.force) {
+		return path
+	}
+	gpath := r.gpart
+	for r.more {
+		r, simple = parsePath(r.path)
+		if !simple {
+			return path
+		}
+		gpath += "." + r.gpart
+	}
+	return gpath
+}
+
 func mustMarshalString(s string) bool {
 	for i := 0; i < len(s); i++ {
 		if s[i] < ' ' || s[i] > 0x7f || s[i] == '"' || s[i] == '\\' {
@@ -121,6 +371,82 @@ func mustMarshalString(s string) bool {
 	return false
 }
 
+const hexDigits = "0123456789abcdef"
+
+// appendString appends s to buf as a json string. Quotes, backslashes, and
+// control characters are always escaped, and invalid UTF-8 is replaced with
+// U+FFFD, as in encoding/json. The html flag escapes '<', '>', and '&', and
+// the ascii flag escapes all non-ASCII characters.
+func appendString(buf []byte, s string, html, ascii bool) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if c >= ' ' && c != '"' && c != '\\' &&
+				(!html || (c != '<' && c != '>' && c != '&')) {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hexDigits[c>>4],
+					hexDigits[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			// these are valid json but not valid javascript
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hexDigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		if ascii {
+			buf = append(buf, s[start:i]...)
+			if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+				buf = appendRuneEscape(buf, r1)
+				buf = appendRuneEscape(buf, r2)
+			} else {
+				buf = appendRuneEscape(buf, r)
+			}
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// appendRuneEscape appends the \uXXXX escape sequence for a rune in the
+// basic multilingual plane.
+func appendRuneEscape(buf []byte, r rune) []byte {
+	return append(buf, '\\', 'u', hexDigits[r>>12&0xF], hexDigits[r>>8&0xF],
+		hexDigits[r>>4&0xF], hexDigits[r&0xF])
+}
+
 // appendStringify makes a json string and appends to buf.
 func appendStringify(buf []byte, s string) []byte {
 	if mustMarshalString(s) {
@@ -223,7 +549,6 @@ loop:
 						if buf[i] == '"' {
 							i--
 							if i >= 0 && buf[i] == '\\' {
-								i--
 								continue
 							}
 							for ; i >= 0; i-- {
@@ -246,151 +571,325 @@ loop:
 	return buf, false
 }
 
+// deleteRange returns the region to remove from jstr for deleting the value
+// at index, which includes the key of an object member and a separating
+// comma.
+func deleteRange(jstr string, index, n int) (start, end int) {
+	prefix := *(*[]byte)(unsafe.Pointer(&sliceHeader{
+		data: (*stringHeader)(unsafe.Pointer(&jstr)).data,
+		len:  index, cap: index}))
+	var exidx int // additional forward stripping
+	prefix, delNextComma := deleteTailItem(prefix)
+	if delNextComma {
+		i, j := index+n, 0
+		for ; i < len(jstr); i, j = i+1, j+1 {
+			if jstr[i] <= ' ' {
+				continue
+			}
+			if jstr[i] == ',' {
+				exidx = j + 1
+			}
+			break
+		}
+	}
+	return len(prefix), index + n + exidx
+}
+
+// arrayInsertRaw inserts a raw value into an array, directly ahead of an
+// existing element.
+func arrayInsertRaw(jstr string, elem gjson.Result, raw string) []byte {
+	buf := make([]byte, 0, len(jstr)+len(raw)+1)
+	buf = append(buf, jstr[:elem.Index]...)
+	buf = append(buf, raw...)
+	buf = append(buf, ',')
+	buf = append(buf, jstr[elem.Index:]...)
+	return buf
+}
+
+// edit is a single change to a json document. The new document is made up
+// of jstr[lead:start], followed by mid, followed by jstr[end:].
+type edit struct {
+	lead       int    // leading bytes of the document that are dropped
+	start      int    // start of the replaced region
+	end        int    // end of the replaced region
+	mid        string // new bytes for the replaced region
+	quote      bool   // mid is a string value that needs quotes
+	optimistic bool   // the edit is a replacement of an existing value
+	exists     bool   // the edit changes or deletes an existing value
+	kind       int    // the kind of edit
+	open       int    // start of the container for member and element edits
+}
+
+const (
+	editValue   = iota // mid replaces a value
+	editMember         // mid adds a member to the end of an object
+	editElement        // mid adds an element to the end of an array
+)
+
+// size returns the length of the new document.
+func (e *edit) size(jstr string) int {
+	n := len(jstr) - e.lead - (e.end - e.start) + len(e.mid)
+	if e.quote {
+		n += 2
+	}
+	return n
+}
+
+// appendMid appends the new bytes for the replaced region to buf.
+func (e *edit) appendMid(buf []byte) []byte {
+	if e.quote {
+		buf = append(buf, '"')
+		buf = append(buf, e.mid...)
+		return append(buf, '"')
+	}
+	return append(buf, e.mid...)
+}
+
+// append appends the new document to buf.
+func (e *edit) append(buf []byte, jstr string) []byte {
+	buf = append(buf, jstr[e.lead:e.start]...)
+	buf = e.appendMid(buf)
+	return append(buf, jstr[e.end:]...)
+}
+
+// valueEdit returns an edit that replaces the region with a value.
+func valueEdit(start, end int, raw string, stringify bool) edit {
+	e := edit{start: start, end: end, mid: raw}
+	if stringify {
+		if mustMarshalString(raw) {
+			b := appendStringify(nil, raw)
+			e.mid = *(*string)(unsafe.Pointer(&b))
+		} else {
+			e.quote = true
+		}
+	}
+	return e
+}
+
 var errNoChange = &errorType{"no change"}
 
-func appendRawPaths(buf []byte, jstr string, paths []pathResult, raw string,
-	stringify, del bool) ([]byte, error) {
-	var err error
+// bom is the UTF-8 byte order mark.
+const bom = "\xef\xbb\xbf"
+
+// containerClosed returns true if the object or array ends with its closing
+// bracket.
+func containerClosed(cjson string) bool {
+	cjson = trim(cjson)
+	if len(cjson) < 2 {
+		return false
+	}
+	if cjson[0] == '{' {
+		return cjson[len(cjson)-1] == '}'
+	}
+	return cjson[len(cjson)-1] == ']'
+}
+
+// negativeIndex returns the index of a path component that counts from the
+// end of an array, such as -1 for the last element.
+func negativeIndex(r pathResult) (int, bool) {
+	if r.force || len(r.part) < 2 || len(r.part) > 19 || r.part[0] != '-' {
+		return 0, false
+	}
+	n, ok := atoui(pathResult{part: r.part[1:]})
+	if !ok || n == 0 {
+		return 0, false
+	}
+	return -n, true
+}
+
+// nthMember returns the value of the nth member of the object in jstr that
+// has the key, or an empty result when there are not enough members.
+func nthMember(jstr, key string, n int) gjson.Result {
+	obj := gjson.Parse(jstr)
+	if !obj.IsObject() {
+		return gjson.Result{}
+	}
+	for ; obj.Index < len(jstr); obj.Index++ {
+		if jstr[obj.Index] > ' ' {
+			break
+		}
+	}
+	var res gjson.Result
+	obj.ForEach(func(k, v gjson.Result) bool {
+		if k.String() == key {
+			if n == 0 {
+				res = v
+				return false
+			}
+			n--
+		}
+		return true
+	})
+	return res
+}
+
+// rawPathsEdit returns the edit for setting or deleting the paths in jstr,
+// which is located at the base offset of the original document.
+func rawPathsEdit(jstr string, base int, paths []pathResult, raw string,
+	stringify, del bool, opts *Options) (edit, error) {
 	var res gjson.Result
 	var found bool
 	if del {
-		if paths[0].part == "-1" && !paths[0].force {
+		if n, ok := negativeIndex(paths[0]); ok {
+			// count from the end of the array, out of range is not found
 			res = gjson.Get(jstr, "#")
-			if res.Int() > 0 {
-				res = gjson.Get(jstr, strconv.FormatInt(int64(res.Int()-1), 10))
+			if res.Int()+int64(n) >= 0 {
+				res = gjson.Get(jstr, strconv.FormatInt(res.Int()+int64(n), 10))
 				found = true
 			}
 		}
 	}
 	if !found {
-		res = gjson.Get(jstr, paths[0].gpart)
+		if len(paths) == 1 && opts != nil && opts.DuplicateKey > 0 {
+			res = nthMember(jstr, paths[0].part, opts.DuplicateKey)
+			if res.Index == 0 && !del {
+				return edit{}, &PathError{Offset: -1, Err: ErrPathNotFound}
+			}
+		} else {
+			res = gjson.Get(jstr, paths[0].gpart)
+		}
 	}
 	if res.Index > 0 {
 		if len(paths) > 1 {
-			buf = append(buf, jstr[:res.Index]...)
-			buf, err = appendRawPaths(buf, res.Raw, paths[1:], raw,
-				stringify, del)
-			if err != nil {
-				return nil, err
-			}
-			buf = append(buf, jstr[res.Index+len(res.Raw):]...)
-			return buf, nil
+			return rawPathsEdit(res.Raw, base+res.Index, paths[1:], raw,
+				stringify, del, opts)
 		}
-		buf = append(buf, jstr[:res.Index]...)
-		var exidx int // additional forward stripping
 		if del {
-			var delNextComma bool
-			buf, delNextComma = deleteTailItem(buf)
-			if delNextComma {
-				i, j := res.Index+len(res.Raw), 0
-				for ; i < len(jstr); i, j = i+1, j+1 {
-					if jstr[i] <= ' ' {
-						continue
-					}
-					if jstr[i] == ',' {
-						exidx = j + 1
-					}
-					break
-				}
-			}
-		} else {
-			if stringify {
-				buf = appendStringify(buf, raw)
-			} else {
-				buf = append(buf, raw...)
-			}
+			start, end := deleteRange(jstr, res.Index, len(res.Raw))
+			return edit{start: base + start, end: base + end, exists: true},
+				nil
 		}
-		buf = append(buf, jstr[res.Index+len(res.Raw)+exidx:]...)
-		return buf, nil
+		e := valueEdit(base+res.Index, base+res.Index+len(res.Raw), raw,
+			stringify)
+		e.exists = true
+		return e, nil
 	}
 	if del {
-		return nil, errNoChange
+		return edit{}, errNoChange
 	}
 	n, numeric := atoui(paths[0])
-	isempty := true
-	for i := 0; i < len(jstr); i++ {
-		if jstr[i] > ' ' {
-			isempty = false
+	lead := 0
+	for ; lead < len(jstr); lead++ {
+		if jstr[lead] > ' ' {
 			break
 		}
 	}
-	if isempty {
-		if numeric {
-			jstr = "[]"
+	// cjson is the container that the new value is added to. When jstr is
+	// not a container then it's replaced by a new one.
+	var cjson string
+	replace := lead == len(jstr)
+	if !replace {
+		jsres := gjson.Parse(jstr)
+		if jsres.Type != gjson.JSON {
+			replace = true
 		} else {
-			jstr = "{}"
+			cjson = jsres.Raw
+			if !containerClosed(cjson) {
+				return edit{}, &PathError{Offset: base + lead,
+					Err: ErrInvalidJSON}
+			}
 		}
 	}
-	jsres := gjson.Parse(jstr)
-	if jsres.Type != gjson.JSON {
+	if replace && lead < len(jstr) && opts != nil && opts.StrictContainers {
+		return edit{}, &PathError{Offset: base + lead, Err: ErrNotAContainer}
+	}
+	if opts != nil && opts.DisableAutoCreate &&
+		(len(paths) > 1 || (replace && (base > 0 || lead < len(jstr)))) {
+		// a missing container would be created
+		return edit{}, &PathError{Offset: base + lead, Err: ErrPathNotFound}
+	}
+	if replace {
 		if numeric {
-			jstr = "[]"
+			cjson = "[]"
 		} else {
-			jstr = "{}"
+			cjson = "{}"
+		}
+	}
+	var ress []gjson.Result
+	if cjson[0] == '[' && numeric {
+		ress = gjson.Parse(cjson).Array()
+	}
+	if opts != nil && (opts.MaxArrayGrowth > 0 || opts.NoNullPadding) {
+		// count the nulls added to this array and to the new ones
+		var padding int
+		if cjson[0] == '[' && numeric {
+			padding = n - len(ress)
+		}
+		for i := 1; i < len(paths); i++ {
+			if n, ok := atoui(paths[i]); ok {
+				padding += n
+			}
+		}
+		if opts.NoNullPadding && padding > 0 {
+			return edit{}, &PathError{Offset: base + lead,
+				Err: ErrIndexOutOfRange}
+		}
+		if opts.MaxArrayGrowth > 0 && padding > opts.MaxArrayGrowth {
+			return edit{}, &PathError{Offset: base + lead, Err: ErrArrayGrowth}
 		}
-		jsres = gjson.Parse(jstr)
 	}
 	var comma bool
-	for i := 1; i < len(jsres.Raw); i++ {
-		if jsres.Raw[i] <= ' ' {
+	for i := 1; i < len(cjson); i++ {
+		if cjson[i] <= ' ' {
 			continue
 		}
-		if jsres.Raw[i] == '}' || jsres.Raw[i] == ']' {
+		if cjson[i] == '}' || cjson[i] == ']' {
 			break
 		}
 		comma = true
 		break
 	}
-	switch jsres.Raw[0] {
+	// keep is the number of leading container bytes that are kept, the buf
+	// holds the new bytes which follow.
+	var keep, kind int
+	var buf []byte
+	switch cjson[0] {
 	default:
-		return nil, &errorType{"json must be an object or array"}
+		return edit{}, &PathError{Offset: base, Err: ErrInvalidJSON}
 	case '{':
-		end := len(jsres.Raw) - 1
+		end := len(cjson) - 1
 		for ; end > 0; end-- {
-			if jsres.Raw[end] == '}' {
+			if cjson[end] == '}' {
 				break
 			}
 		}
-		buf = append(buf, jsres.Raw[:end]...)
+		keep, kind = end, editMember
 		if comma {
 			buf = append(buf, ',')
 		}
 		buf = appendBuild(buf, false, paths, raw, stringify)
 		buf = append(buf, '}')
-		return buf, nil
 	case '[':
 		var appendit bool
 		if !numeric {
 			if paths[0].part == "-1" && !paths[0].force {
 				appendit = true
 			} else {
-				return nil, &errorType{
-					"cannot set array element for non-numeric key '" +
-						paths[0].part + "'"}
+				return edit{}, &PathError{Offset: base, Err: ErrInvalidPath}
 			}
 		}
 		if appendit {
-			njson := trim(jsres.Raw)
+			njson := trim(cjson)
 			if njson[len(njson)-1] == ']' {
 				njson = njson[:len(njson)-1]
 			}
-			buf = append(buf, njson...)
+			keep, kind = len(njson), editElement
 			if comma {
 				buf = append(buf, ',')
 			}
-
 			buf = appendBuild(buf, true, paths, raw, stringify)
 			buf = append(buf, ']')
-			return buf, nil
+			break
 		}
-		buf = append(buf, '[')
-		ress := jsres.Array()
-		for i := 0; i < len(ress); i++ {
-			if i > 0 {
-				buf = append(buf, ',')
+		// the padding is added before the closing bracket, keeping the
+		// existing elements as they are
+		end := len(cjson) - 1
+		for ; end > 0; end-- {
+			if cjson[end] == ']' {
+				break
 			}
-			buf = append(buf, ress[i].Raw...)
 		}
+		keep, kind = end, editElement
 		if len(ress) == 0 {
 			buf = appendRepeat(buf, "null,", n-len(ress))
 		} else {
@@ -401,8 +900,15 @@ func appendRawPaths(buf []byte, jstr string, paths []pathResult, raw string,
 		}
 		buf = appendBuild(buf, true, paths, raw, stringify)
 		buf = append(buf, ']')
-		return buf, nil
 	}
+	if replace {
+		buf = append([]byte(cjson[:keep]), buf...)
+		return edit{start: base, end: base + len(jstr),
+			mid: *(*string)(unsafe.Pointer(&buf))}, nil
+	}
+	return edit{lead: lead, start: base + lead + keep, end: base + len(jstr),
+		mid: *(*string)(unsafe.Pointer(&buf)), kind: kind,
+		open: base + lead}, nil
 }
 
 func isOptimisticPath(path string) bool {
@@ -426,7 +932,25 @@ func isOptimisticPath(path string) bool {
 // Invalid json will not panic, but it may return back unexpected results.
 // An error is returned if the path is not valid.
 //
-// A path is a series of keys separated by a dot.
+// Values other than strings, booleans, and numbers are encoded using
+// encoding/json, which calls the MarshalJSON or MarshalText method of types
+// such as time.Time and net.IP. Structs and maps become json objects that
+// honor the struct field tags. Values that cannot be encoded, such as
+// channels and functions, return an error. The json.Number, *big.Int, and
+// *big.Float types are written as numbers without losing precision. A nil
+// value is written as null and never deletes the path.
+//
+// A gjson.Result value is written as its raw json, except for numbers which
+// are formatted from their float64 value unless the PreserveNumberText
+// option is set. A number that is out of the range of a float64 keeps its
+// original text. A result that does not exist is written as null.
+//
+// When an object has duplicate keys, the first occurrence of the key is
+// used. The DuplicateKey option of SetOptions selects another occurrence.
+//
+// A path is a series of keys separated by a dot. A key that contains a dot,
+// or the '#', '*', and '?' characters which otherwise refer to arrays and
+// wildcards, is escaped with a '\', such as `counts.\#total`.
 //
 //	{
 //	  "name": {"first": "Tom", "last": "Anderson"},
@@ -454,6 +978,9 @@ func SetBytes(json []byte, path string, value interface{}) ([]byte, error) {
 // SetRaw sets a raw json value for the specified path.
 // This function works the same as Set except that the value is set as a
 // raw block of json. This allows for setting premarshalled json objects.
+// The value is inserted byte for byte, including multi-byte UTF-8 and any
+// leading or trailing whitespace, and it is never escaped or normalized
+// unless an option that formats the result, such as Pretty, is used.
 func SetRaw(json, path, value string) (string, error) {
 	return SetRawOptions(json, path, value, nil)
 }
@@ -462,14 +989,14 @@ func SetRaw(json, path, value string) (string, error) {
 // This furnction works the same as SetOptions except that the value is set
 // as a raw block of json. This allows for setting premarshalled json objects.
 func SetRawOptions(json, path, value string, opts *Options) (string, error) {
-	var optimistic bool
-	if opts != nil {
-		optimistic = opts.Optimistic
-	}
-	res, err := set(json, path, value, false, false, optimistic, false)
-	if err == errNoChange {
-		return json, nil
+	if opts != nil && opts.ReplaceInPlace {
+		// it's not safe to replace bytes in-place for strings
+		nopts := *opts
+		opts = &nopts
+		opts.ReplaceInPlace = false
 	}
+	res, err := SetRawBytesOptions(stringBytes(json), path,
+		stringBytes(value), opts)
 	return string(res), err
 }
 
@@ -480,111 +1007,2006 @@ func SetRawBytes(json []byte, path string, value []byte) ([]byte, error) {
 	return SetRawBytesOptions(json, path, value, nil)
 }
 
-type dtype struct{}
+// SetRawValidate sets a raw json value for the specified path after checking
+// that the value is valid json. When it's not valid, the json is returned
+// unchanged along with an ErrInvalidJSON error for the path, and nothing is
+// set.
+func SetRawValidate(json, path, value string) (string, error) {
+	if !gjson.Valid(value) {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidJSON}
+	}
+	return SetRaw(json, path, value)
+}
 
-// Delete deletes a value from json for the specified path.
-func Delete(json, path string) (string, error) {
-	return Set(json, path, dtype{})
+// SetRawBytesValidate sets a raw json value for the specified path after
+// checking that the value is valid json.
+// If working with bytes, this method preferred over
+// SetRawValidate(string(data), path, string(value))
+func SetRawBytesValidate(json []byte, path string, value []byte) ([]byte,
+	error) {
+	if !gjson.ValidBytes(value) {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidJSON}
+	}
+	return SetRawBytes(json, path, value)
 }
 
-// DeleteBytes deletes a value from json for the specified path.
-func DeleteBytes(json []byte, path string) ([]byte, error) {
-	return SetBytes(json, path, dtype{})
+// RawPathValue is a path and a raw block of json for SetRawMany.
+type RawPathValue struct {
+	Path string
+	JSON string
 }
 
-type stringHeader struct {
-	data unsafe.Pointer
-	len  int
+// SetRawMany sets many raw json values, in order, as if SetRaw was called for
+// each pair. Each raw value is validated before it's set, and the original
+// json is returned along with an error identifying the index of the pair
+// when a value is invalid or cannot be set.
+func SetRawMany(json string, pairs []RawPathValue) (string, error) {
+	// the edits alternate between two buffers that are reused
+	var cur, next []byte
+	jstr := json
+	for i, pair := range pairs {
+		if !gjson.Valid(pair.JSON) {
+			return json, fmt.Errorf("pair %d: %w", i,
+				&PathError{Path: pair.Path, Offset: -1, Err: ErrInvalidJSON})
+		}
+		e, err := pathEdit(jstr, pair.Path, pair.JSON, false, false, nil)
+		if err == errNoChange {
+			continue
+		}
+		if err != nil {
+			return json, fmt.Errorf("pair %d: %w", i, err)
+		}
+		next = e.append(next[:0], jstr)
+		cur, next = next, cur
+		jstr = *(*string)(unsafe.Pointer(&cur))
+	}
+	if cur == nil {
+		return json, nil
+	}
+	return string(cur), nil
 }
 
-type sliceHeader struct {
-	data unsafe.Pointer
-	len  int
-	cap  int
+// PathValue is a path and a value for SetMany.
+type PathValue struct {
+	Path  string
+	Value interface{}
 }
 
-func setByGetResult(jstr, raw string, res gjson.Result,
-	stringify, del, optimistic, inplace bool) ([]byte, error) {
+// SetMany sets many values, in order, as if Set was called for each pair.
+// The original json is returned along with an error identifying the index
+// of the pair when a value cannot be set.
+func SetMany(json string, pairs []PathValue) (string, error) {
+	return SetManyContext(context.Background(), json, pairs)
+}
 
-	sz := len(jstr) - len(res.Raw) + len(raw)
-	if stringify {
-		sz += 2
+// contextScanSize is the size of a document at which SetManyContext stops
+// waiting for a pair when the context is done during its scan.
+const contextScanSize = 1 << 20
+
+// SetManyContext is like SetMany, but stops when the context is done. The
+// context is checked before each pair, and while a pair is scanning a
+// document of a megabyte or more. When it's done the original json is
+// returned along with the context error, wrapped with the number of pairs
+// that were applied. A scan that is stopped runs to its end in the
+// background on a copy of the document, which is then discarded.
+func SetManyContext(ctx context.Context, json string, pairs []PathValue) (
+	string, error) {
+	if len(pairs) == 0 {
+		return json, nil
 	}
-	if inplace && sz <= len(jstr) {
-		if !stringify || !mustMarshalString(raw) {
-			jsonh := *(*stringHeader)(unsafe.Pointer(&jstr))
-			jsonbh := sliceHeader{
-				data: jsonh.data, len: jsonh.len, cap: jsonh.len}
-			jbytes := *(*[]byte)(unsafe.Pointer(&jsonbh))
-			if stringify {
-				jbytes[res.Index] = '"'
-				copy(jbytes[res.Index+1:], []byte(raw))
-				jbytes[res.Index+1+len(raw)] = '"'
-				copy(jbytes[res.Index+1+len(raw)+1:],
-					jbytes[res.Index+len(res.Raw):])
-			} else {
-				copy(jbytes[res.Index:], []byte(raw))
-				copy(jbytes[res.Index+len(raw):],
-					jbytes[res.Index+len(res.Raw):])
+	ed := NewEditor([]byte(json))
+	for i, pair := range pairs {
+		if err := ctx.Err(); err != nil {
+			return json, fmt.Errorf("applied %d of %d pairs: %w", i,
+				len(pairs), err)
+		}
+		raw, stringify, del, err := valueRaw(pair.Value, nil)
+		if err != nil {
+			return json, fmt.Errorf("pair %d: %w", i,
+				&PathError{Path: pair.Path, Offset: -1, Err: err})
+		}
+		if ctx.Done() == nil || len(ed.buf) < contextScanSize {
+			err = ed.apply(pair.Path, raw, stringify, del)
+		} else {
+			// the raw value may share the bytes of the value, which must
+			// not be used after returning
+			raw = string([]byte(raw))
+			done := make(chan error, 1)
+			go func() {
+				done <- ed.apply(pair.Path, raw, stringify, del)
+			}()
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				return json, fmt.Errorf("applied %d of %d pairs: %w", i,
+					len(pairs), ctx.Err())
 			}
-			return jbytes, nil
 		}
-		return []byte(jstr), nil
-	}
-	buf := make([]byte, 0, sz)
-	buf = append(buf, jstr[:res.Index]...)
-	if stringify {
-		buf = appendStringify(buf, raw)
-	} else {
-		buf = append(buf, raw...)
+		if err != nil {
+			return json, fmt.Errorf("pair %d: %w", i, err)
+		}
 	}
-	buf = append(buf, jstr[res.Index+len(res.Raw):]...)
-	return buf, nil
+	return string(ed.Bytes()), nil
 }
 
-func setManyByGetResult(jstr string, raws []interface{}, valueDiff int, ress []gjson.Result,
-	stringify, inplace bool) ([]byte, error) {
-
-	var blen int
+// SetMap sets the values of the map, where each key is a path. The paths
+// are set in sorted order, so that the result is deterministic and a parent
+// path is set before its children.
+func SetMap(json string, m map[string]interface{}) (string, error) {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return SetOrdered(json, keys, m)
+}
 
-	if stringify {
-		valueDiff += 2 * len(raws)
+// SetOrdered sets the values of the map in the order of the keys, where each
+// key is a path. The original json is returned along with an error
+// identifying the key when a value cannot be set, or when a key is not in
+// the map.
+func SetOrdered(json string, keys []string, m map[string]interface{}) (string,
+	error) {
+	if len(keys) == 0 {
+		return json, nil
+	}
+	ed := NewEditor([]byte(json))
+	for _, key := range keys {
+		value, ok := m[key]
+		if !ok {
+			return json, &errorType{"key '" + key + "' is not in the map"}
+		}
+		if err := ed.Set(key, value); err != nil {
+			return json, fmt.Errorf("key '%s': %w", key, err)
+		}
 	}
+	return string(ed.Bytes()), nil
+}
 
-	blen = len(jstr) + valueDiff
-	var buf = make([]byte, len(jstr))
-	copy(buf, jstr)
-	if !inplace {
-		return nil, fmt.Errorf("not supported if replace is not inplace")
+// SetObject sets the fields of the object at parentPath, where each key of
+// the map is a key of the object, not a path. The object is found once and
+// the fields are set in sorted order. When parentPath does not exist, or
+// is not an object or array, a new object is created. An empty parentPath
+// is the root of the document.
+func SetObject(json, parentPath string, fields map[string]interface{}) (
+	string, error) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
 	}
-	jsonh := *(*stringHeader)(unsafe.Pointer(&jstr))
-	jsonbh := sliceHeader{
-		data: jsonh.data, len: blen, cap: blen}
-	jbytes := *(*[]byte)(unsafe.Pointer(&jsonbh))
-	var rwb []byte
-	var diff int
-	for i := 0; i < len(ress); i++ {
-		raw := raws[i]
-		res := ress[i]
-		rwb = getBytes(raw)
-		var currentSz int
+	sort.Strings(keys)
+	return SetObjectOrdered(json, parentPath, keys, fields)
+}
 
-		currentSz = len(rwb) - len(res.Raw)
+// SetObjectOrdered sets the fields of the object at parentPath in the order
+// of the keys. An error is returned when a key is not in the map, and when
+// parentPath is an array.
+func SetObjectOrdered(json, parentPath string, keys []string,
+	fields map[string]interface{}) (string, error) {
+	var obj string
+	if parentPath == "" {
+		obj = json
+	} else if res := getPath(json, parentPath); res.IsObject() {
+		obj = res.Raw
+	} else if res.IsArray() {
+		return json, &PathError{Path: parentPath, Offset: res.Index,
+			Err: ErrInvalidPath}
+	} else {
+		obj = "{}"
+	}
+	ed := NewEditor([]byte(obj))
+	for _, key := range keys {
+		value, ok := fields[key]
+		if !ok {
+			return json, &errorType{"key '" + key + "' is not in the map"}
+		}
+		if err := ed.Set(escapeKey(key), value); err != nil {
+			return json, fmt.Errorf("key '%s': %w", key, err)
+		}
+	}
+	if parentPath == "" {
+		return string(ed.Bytes()), nil
+	}
+	return SetRaw(json, parentPath, string(ed.Bytes()))
+}
 
-		if stringify {
-			currentSz += 2
+// SetTemplate sets a json value for the path made by replacing each
+// placeholder in the template, such as "{id}" in "users.{id}.name", with its
+// value in vars. The substituted values are escaped so that a dot, or
+// another path character, is part of the key. A number is not escaped, so
+// it can be an array index. Use `\{` for a literal '{' in the template. An
+// error is returned when a placeholder is not in vars, or is not closed.
+func SetTemplate(json, template string, vars map[string]string,
+	value interface{}) (string, error) {
+	path, err := expandTemplate(template, vars)
+	if err != nil {
+		return json, err
+	}
+	return Set(json, path, value)
+}
 
-			jbytes[res.Index+diff] = '"'
-			copy(jbytes[res.Index+1+diff:], rwb) // 1 index
-			jbytes[res.Index+1+len(rwb)+diff] = '"'
-			if i+1 < len(ress) {
-				copy(jbytes[res.Index+1+len(rwb)+1+diff:],
-					buf[res.Index+len(res.Raw):ress[i+1].Index]) // next index, copy from index + len + till next index
-			} else {
-				copy(jbytes[res.Index+1+len(rwb)+1+diff:], // last index
-					buf[res.Index+len(res.Raw):])
+// expandTemplate returns the path for the template of SetTemplate.
+func expandTemplate(template string, vars map[string]string) (string,
+	error) {
+	var buf []byte
+	for i := 0; i < len(template); i++ {
+		switch template[i] {
+		case '\\':
+			buf = append(buf, template[i])
+			if i+1 < len(template) {
+				i++
+				buf = append(buf, template[i])
 			}
-			diff += currentSz
+			continue
+		case '{':
+		default:
+			buf = append(buf, template[i])
+			continue
+		}
+		end := strings.IndexByte(template[i:], '}')
+		if end == -1 {
+			return "", &PathError{Path: template, Offset: -1,
+				Err: &errorType{"placeholder is not closed"}}
+		}
+		name := template[i+1 : i+end]
+		val, ok := vars[name]
+		if !ok {
+			return "", &PathError{Path: template, Offset: -1,
+				Err: &errorType{"placeholder '" + name + "' is not filled"}}
+		}
+		if len(val) > 0 && val[0] == ':' {
+			buf = append(buf, '\\')
+		}
+		for j := 0; j < len(val); j++ {
+			if !isSafeKeyChar(val[j]) {
+				buf = append(buf, '\\')
+			}
+			buf = append(buf, val[j])
+		}
+		i += end
+	}
+	return string(buf), nil
+}
+
+// SetTyped sets a string value for the specified path, converting it to the
+// json type that it represents. The strings "true", "false", and "null"
+// become literals, a string that's a valid json number, such as "42" or
+// "3.14", is written as that number, and everything else is written as a
+// string. Numbers with leading zeros, such as "007", remain strings.
+func SetTyped(json, path, value string) (string, error) {
+	return SetTypedOptions(json, path, value, nil)
+}
+
+// TypedOptions represents additional options for the SetTypedOptions
+// function.
+type TypedOptions struct {
+	// Options are used for setting the value.
+	Options
+	// ForceString writes the value as a string without converting it to
+	// another json type.
+	ForceString bool
+}
+
+// SetTypedOptions sets a string value for the specified path with options,
+// converting it to the json type that it represents unless the ForceString
+// option is set.
+func SetTypedOptions(json, path, value string, topts *TypedOptions) (string,
+	error) {
+	var opts *Options
+	if topts != nil {
+		opts = &topts.Options
+		if topts.ForceString {
+			return SetOptions(json, path, value, opts)
+		}
+	}
+	switch value {
+	case "true", "false", "null":
+	default:
+		if !validNumber(value) {
+			return SetOptions(json, path, value, opts)
+		}
+	}
+	return SetRawOptions(json, path, value, opts)
+}
+
+// SetLiteral sets a json value for the path made of the keys, where each key
+// is used literally as an object key. No characters of the keys need to be
+// escaped, including dots, colons, backslashes, wildcards, newlines, and
+// other control characters.
+func SetLiteral(json string, keys []string, value interface{}) (string,
+	error) {
+	return Set(json, literalPath(keys), value)
+}
+
+// DeleteLiteral deletes a value for the path made of the keys, where each
+// key is used literally as an object key.
+func DeleteLiteral(json string, keys []string) (string, error) {
+	return Delete(json, literalPath(keys))
+}
+
+// literalPath returns the path for the literal object keys.
+func literalPath(keys []string) string {
+	var path string
+	for i, key := range keys {
+		if i > 0 {
+			path += "."
+		}
+		path += escapeKey(key)
+	}
+	return path
+}
+
+// SetKeys sets a json value for the path made of the keys. Each key is used
+// as is, without any escaping. A key made of digits, or "-1", is an array
+// index for an array and an object key otherwise, the same as in a path.
+// Prefix a key with a ':' to always use the rest of it as an object key,
+// such as ":2" for the key "2", or "::a" for the key ":a".
+func SetKeys(json string, keys []string, value interface{}) (string, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: keysPath(keys), Offset: -1, Err: err}
+	}
+	return setKeys(json, keys, raw, stringify, del)
+}
+
+// SetIntKey sets a json value for the object key that is the decimal form
+// of key, such as "5", in the object at parentPath. The key is always an
+// object key and never an array index, so the object is created when
+// parentPath does not exist, and ErrInvalidPath is returned when it's an
+// array. An empty parentPath is the root of the document.
+func SetIntKey(json, parentPath string, key int, value interface{}) (string,
+	error) {
+	path := joinPath(parentPath, escapeKey(strconv.Itoa(key)))
+	var parent gjson.Result
+	if parentPath == "" {
+		parent = gjson.Parse(json)
+	} else {
+		parent = getPath(json, parentPath)
+	}
+	if parent.IsArray() {
+		// an existing array is indexed even with a forced key
+		return json, &PathError{Path: path, Offset: parent.Index,
+			Err: ErrInvalidPath}
+	}
+	return Set(json, path, value)
+}
+
+// SetRawKeys sets a raw json value for the path made of the keys, which are
+// used in the same way as SetKeys.
+func SetRawKeys(json string, keys []string, value string) (string, error) {
+	return setKeys(json, keys, value, false, false)
+}
+
+// DeleteKeys deletes a value for the path made of the keys, which are used
+// in the same way as SetKeys.
+func DeleteKeys(json string, keys []string) (string, error) {
+	return setKeys(json, keys, "", false, true)
+}
+
+func setKeys(json string, keys []string, raw string, stringify,
+	del bool) (string, error) {
+	if len(keys) == 0 {
+		return json, ErrEmptyPath
+	}
+	paths := make([]pathResult, len(keys))
+	for i, key := range keys {
+		r := &paths[i]
+		if len(key) > 0 && key[0] == ':' {
+			r.force, key = true, key[1:]
+		} else if key == "" {
+			// an empty key is never an index
+			r.force = true
+		}
+		r.part = key
+		r.more = i < len(keys)-1
+		// escape the key for gjson
+		var gpart []byte
+		for j := 0; j < len(key); j++ {
+			if !isSafeKeyChar(key[j]) {
+				gpart = append(gpart, '\\')
+			}
+			gpart = append(gpart, key[j])
+		}
+		r.gpart = string(gpart)
+	}
+	e, err := pathsEdit(json, keysPath(keys), paths, true, raw, stringify,
+		del, nil)
+	if err == errNoChange {
+		return json, nil
+	}
+	if err != nil {
+		return json, err
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), nil
+}
+
+// keysPath returns the path that is equivalent to the keys of SetKeys.
+func keysPath(keys []string) string {
+	var path string
+	for i, key := range keys {
+		if i > 0 {
+			path += "."
+		}
+		if len(key) > 0 && key[0] == ':' {
+			path += escapeKey(key[1:])
+		} else if _, numeric := atoui(pathResult{part: key}); key == "-1" ||
+			(numeric && key != "") {
+			path += key
+		} else {
+			path += escapeKey(key)
+		}
+	}
+	return path
+}
+
+// SetTopLevel sets a json value for a key of the top-level object. The key
+// is used literally, without any path syntax. This is the fastest way to set
+// a top-level value, because the object is scanned directly. When the json
+// is not an object, it works the same as SetLiteral with the one key.
+func SetTopLevel(json, key string, value interface{}) (string, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: escapeKey(key), Offset: -1, Err: err}
+	}
+	e, err := topLevelEdit(json, key, raw, stringify, del)
+	if err == errNotTopLevel {
+		e, err = pathEdit(json, escapeKey(key), raw, stringify, del, nil)
+	}
+	if err == errNoChange {
+		return json, nil
+	}
+	if err != nil {
+		return json, err
+	}
+	buf := e.append(make([]byte, 0, e.size(json)), json)
+	return *(*string)(unsafe.Pointer(&buf)), nil
+}
+
+// SetNull sets the value at the specified path to an explicit null. This is
+// the same as Set with a nil value, which never deletes the path. Use Delete
+// to remove a value.
+func SetNull(json, path string) (string, error) {
+	return SetRaw(json, path, "null")
+}
+
+// SetRoot replaces the entire json document with the value, which is
+// converted in the same way as the value of Set. An empty path is not the
+// root for the other functions, which return ErrEmptyPath, and the root
+// cannot be deleted.
+func SetRoot(json string, value interface{}) (string, error) {
+	raw, stringify, _, err := valueRaw(value, nil)
+	if err != nil {
+		return json, err
+	}
+	if stringify {
+		return string(appendStringify(nil, raw)), nil
+	}
+	return raw, nil
+}
+
+// SetRawRoot replaces the entire json document with the raw json value.
+// The original json is returned along with ErrInvalidJSON when the value is
+// not valid json.
+func SetRawRoot(json, value string) (string, error) {
+	if !gjson.Valid(value) {
+		return json, &PathError{Offset: -1, Err: ErrInvalidJSON}
+	}
+	return value, nil
+}
+
+type dtype struct{}
+
+// Delete deletes a value from json for the specified path.
+// A negative array index counts from the end of the array, such as -1 for
+// the last element. The json is returned unchanged when the path does not
+// exist, including when an index is out of range.
+func Delete(json, path string) (string, error) {
+	return Set(json, path, dtype{})
+}
+
+// DeleteBytes deletes a value from json for the specified path.
+func DeleteBytes(json []byte, path string) ([]byte, error) {
+	return SetBytes(json, path, dtype{})
+}
+
+// DeleteOptions deletes a value from json for the specified path with
+// options.
+func DeleteOptions(json, path string, opts *Options) (string, error) {
+	return SetOptions(json, path, dtype{}, opts)
+}
+
+// DeleteBytesOptions deletes a value from json for the specified path with
+// options.
+// If working with bytes, this method preferred over
+// DeleteOptions(string(data), path, opts)
+func DeleteBytesOptions(json []byte, path string, opts *Options) ([]byte,
+	error) {
+	return SetBytesOptions(json, path, dtype{}, opts)
+}
+
+// DeleteMany deletes the values for the paths in a single pass. All of the
+// paths refer to the original json, so the indexes of an array are not
+// shifted by the deletion of its other elements, and a path inside of
+// another deleted value is deleted along with it. The deleted regions are
+// found first and then removed with one copy.
+// Paths that do not exist are skipped. An error identifying the index of the
+// path is returned when a path is not valid, along with the original json.
+func DeleteMany(json string, paths []string) (string, error) {
+	type span struct{ start, end int }
+	spans := make([]span, 0, len(paths))
+	for i, path := range paths {
+		e, err := pathEdit(json, path, "", false, true, nil)
+		if err == errNoChange {
+			continue
+		}
+		if err != nil {
+			return json, fmt.Errorf("path %d: %w", i, err)
+		}
+		start, end := deletedItem(json, e)
+		spans = append(spans, span{start, end})
+	}
+	if len(spans) == 0 {
+		return json, nil
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if spans[i].start == spans[j].start {
+			return spans[i].end > spans[j].end
+		}
+		return spans[i].start < spans[j].start
+	})
+	var n int
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[n].end {
+			// inside of the previous value
+			continue
+		}
+		n++
+		spans[n] = spans[i]
+	}
+	spans = spans[:n+1]
+	buf := make([]byte, 0, len(json))
+	var end int
+	for i := 0; i < len(spans); {
+		// a run of sibling values that are only separated by commas
+		start := spans[i].start
+		for i++; i < len(spans); i++ {
+			j := spans[i-1].end
+			for ; j < len(json) && json[j] <= ' '; j++ {
+			}
+			if j == len(json) || json[j] != ',' {
+				break
+			}
+			for j++; j < len(json) && json[j] <= ' '; j++ {
+			}
+			if j != spans[i].start {
+				break
+			}
+		}
+		rend := spans[i-1].end
+		// remove the comma that precedes the run, or the one that follows
+		// it when it's the first in its container
+		j := start - 1
+		for ; j >= 0 && json[j] <= ' '; j-- {
+		}
+		if j >= 0 && json[j] == ',' {
+			start = j
+		} else {
+			if j >= 0 && json[j] == '{' {
+				start = j + 1
+			}
+			k := rend
+			for ; k < len(json) && json[k] <= ' '; k++ {
+			}
+			if k < len(json) && json[k] == ',' {
+				rend = k + 1
+			}
+		}
+		buf = append(buf, json[end:start]...)
+		end = rend
+	}
+	buf = append(buf, json[end:]...)
+	return string(buf), nil
+}
+
+// deletedItem returns the region of the member or element that is removed
+// by the delete edit, without its separating comma.
+func deletedItem(json string, e edit) (start, end int) {
+	start, end = e.start, e.end
+	if start < end && json[start] == ',' {
+		start++
+	}
+	for ; start < end && json[start] <= ' '; start++ {
+	}
+	if end > start && json[end-1] == ',' {
+		for end--; end > start && json[end-1] <= ' '; end-- {
+		}
+	}
+	return start, end
+}
+
+// DeleteAndGet deletes a value from json for the specified path and returns
+// the deleted value, which references the original json so that it remains
+// valid after the delete. When the path does not exist, the json is
+// returned unchanged and the deleted value does not exist.
+func DeleteAndGet(json, path string) (result string, deleted gjson.Result,
+	err error) {
+	e, err := pathEdit(json, path, "", false, true, nil)
+	if err == errNoChange {
+		return json, gjson.Result{}, nil
+	}
+	if err != nil {
+		return json, gjson.Result{}, err
+	}
+	start, end := deletedItem(json, e)
+	if n := valueLen(json[start:end]); n < end-start {
+		// an object member, the value follows the key
+		for start += n; json[start] <= ' ' || json[start] == ':'; start++ {
+		}
+	}
+	deleted = gjson.Parse(json[start:end])
+	deleted.Index = start
+	return string(e.append(make([]byte, 0, e.size(json)), json)), deleted,
+		nil
+}
+
+// Pop deletes the last element of the array at the specified path and
+// returns it, so that the array can be used as a stack. An empty path is
+// the root array. The remaining elements are preserved byte for byte.
+// An error is returned when the path does not exist, when the value is not
+// an array, or when the array is empty.
+func Pop(json, arrayPath string) (result string, popped gjson.Result,
+	err error) {
+	return popElement(json, arrayPath, "-1")
+}
+
+// Shift deletes the first element of the array at the specified path and
+// returns it, so that the array can be used as a queue. It works the same
+// as Pop otherwise.
+func Shift(json, arrayPath string) (result string, shifted gjson.Result,
+	err error) {
+	return popElement(json, arrayPath, "0")
+}
+
+func popElement(json, arrayPath, index string) (string, gjson.Result,
+	error) {
+	var arr gjson.Result
+	if arrayPath == "" {
+		arr = gjson.Parse(json)
+	} else {
+		arr = getPath(json, arrayPath)
+	}
+	switch {
+	case !arr.Exists():
+		return json, gjson.Result{}, &PathError{Path: arrayPath, Offset: -1,
+			Err: ErrPathNotFound}
+	case !arr.IsArray():
+		return json, gjson.Result{}, &PathError{Path: arrayPath,
+			Offset: arr.Index, Err: ErrNotAnArray}
+	case !containerClosed(arr.Raw):
+		return json, gjson.Result{}, &PathError{Path: arrayPath,
+			Offset: arr.Index, Err: ErrInvalidJSON}
+	}
+	if raw := trim(arr.Raw); len(trim(raw[1:len(raw)-1])) == 0 {
+		return json, gjson.Result{}, &PathError{Path: arrayPath,
+			Offset: arr.Index, Err: ErrIndexOutOfRange}
+	}
+	return DeleteAndGet(json, joinPath(arrayPath, index))
+}
+
+// DeleteWhere deletes every array element that matches a query, such as
+// "friends.#(age>60)". The query matches all elements, even when it's not
+// written in the "#(...)#" form. The json is returned unchanged when no
+// elements match.
+func DeleteWhere(json, path string) (string, error) {
+	apath, positions, err := queryPositions(json, path)
+	if err != nil {
+		return json, err
+	}
+	// delete in descending order so the earlier positions remain valid
+	for i := len(positions) - 1; i >= 0; i-- {
+		json, err = Delete(json, joinPath(apath, strconv.Itoa(positions[i])))
+		if err != nil {
+			return json, err
+		}
+	}
+	return json, nil
+}
+
+// DeleteFirstWhere deletes the first array element that matches a query,
+// such as "queue.#(status=\"done\")". The json is returned unchanged when
+// no elements match.
+func DeleteFirstWhere(json, query string) (string, error) {
+	return deleteOneWhere(json, query, false)
+}
+
+// DeleteLastWhere deletes the last array element that matches a query. The
+// json is returned unchanged when no elements match.
+func DeleteLastWhere(json, query string) (string, error) {
+	return deleteOneWhere(json, query, true)
+}
+
+func deleteOneWhere(json, query string, last bool) (string, error) {
+	apath, positions, err := queryPositions(json, query)
+	if err != nil || len(positions) == 0 {
+		return json, err
+	}
+	pos := positions[0]
+	if last {
+		pos = positions[len(positions)-1]
+	}
+	return Delete(json, joinPath(apath, strconv.Itoa(pos)))
+}
+
+// SetWhere sets the field of the first array element that matches a query,
+// such as "friends.#(last=\"Murphy\")", where the field is a path that's
+// relative to the element, such as "last" or "name.first". An empty field
+// replaces the element itself. When all is true, the field is set on every
+// matching element, as with the "#(...)#" form.
+// Unlike setting a path with a query, an error is returned when no elements
+// match, rather than silently leaving the json unchanged.
+func SetWhere(json, query, field string, value interface{}, all bool) (string,
+	error) {
+	apath, positions, err := queryPositions(json, query)
+	if err != nil {
+		return json, err
+	}
+	if len(positions) == 0 {
+		return json, &PathError{Path: query, Offset: -1, Err: ErrPathNotFound}
+	}
+	if !all {
+		positions = positions[:1]
+	}
+	ed := NewEditor([]byte(json))
+	for _, pos := range positions {
+		path := joinPath(apath, strconv.Itoa(pos))
+		if field != "" {
+			path += "." + field
+		}
+		if err := ed.Set(path, value); err != nil {
+			return json, err
+		}
+	}
+	return string(ed.Bytes()), nil
+}
+
+// SetByKey sets the field of the array element whose keyField equals
+// keyValue, such as the "status" of the element with an "id" of 5. The
+// keyField and field are paths that are relative to the element, and the
+// values are compared as json, so that 5 and 5.0 are equal. An error is
+// returned when no element matches, unless the AppendOnNoMatch option of
+// SetByKeyOptions is set.
+func SetByKey(json, arrayPath, keyField string, keyValue interface{},
+	field string, value interface{}) (string, error) {
+	return SetByKeyOptions(json, arrayPath, keyField, keyValue, field, value,
+		nil)
+}
+
+// ByKeyOptions represents additional options for the SetByKeyOptions
+// function.
+type ByKeyOptions struct {
+	// Options are used for setting the field.
+	Options
+	// AppendOnNoMatch appends a new element when no element of the array
+	// matches, rather than returning ErrPathNotFound.
+	AppendOnNoMatch bool
+}
+
+// SetByKeyOptions sets the field of the array element whose keyField equals
+// keyValue with options. When no element matches and the AppendOnNoMatch
+// option is set, a new element that has both the keyField and the field is
+// appended to the array, which is created if it does not exist.
+func SetByKeyOptions(json, arrayPath, keyField string, keyValue interface{},
+	field string, value interface{}, bopts *ByKeyOptions) (string, error) {
+	var opts *Options
+	if bopts != nil {
+		opts = &bopts.Options
+	}
+	if field == "" || keyField == "" {
+		return json, ErrEmptyPath
+	}
+	key, err := appendValue(nil, keyValue)
+	if err != nil {
+		return json, &PathError{Path: keyField, Offset: -1, Err: err}
+	}
+	pos, err := keyPosition(json, arrayPath, keyField, string(key))
+	if err != nil {
+		return json, err
+	}
+	if pos != -1 {
+		return SetOptions(json, joinPath(joinPath(arrayPath,
+			strconv.Itoa(pos)), field), value, opts)
+	}
+	if bopts == nil || !bopts.AppendOnNoMatch {
+		return json, &PathError{Path: arrayPath, Offset: -1,
+			Err: ErrPathNotFound}
+	}
+	elem, err := SetRaw("{}", keyField, string(key))
+	if err == nil {
+		elem, err = Set(elem, field, value)
+	}
+	if err != nil {
+		return json, err
+	}
+	return SetRawOptions(json, joinPath(arrayPath, "-1"), elem, opts)
+}
+
+// UpsertByKey replaces the array element whose keyField equals the keyField
+// of the element, or appends the element when no element matches. The array
+// is created if it does not exist, and the other elements are preserved byte
+// for byte. An error is returned when the element does not have the
+// keyField.
+func UpsertByKey(json, arrayPath, keyField string, element interface{}) (
+	string, error) {
+	raw, err := appendValue(nil, element)
+	if err != nil {
+		return json, &PathError{Path: arrayPath, Offset: -1, Err: err}
+	}
+	key := getPath(string(raw), keyField)
+	if !key.Exists() {
+		return json, &PathError{Path: keyField, Offset: -1,
+			Err: ErrPathNotFound}
+	}
+	pos, err := keyPosition(json, arrayPath, keyField, key.Raw)
+	if err != nil {
+		return json, err
+	}
+	if pos == -1 {
+		return SetRaw(json, joinPath(arrayPath, "-1"), string(raw))
+	}
+	return SetRaw(json, joinPath(arrayPath, strconv.Itoa(pos)), string(raw))
+}
+
+// keyPosition returns the position of the first element of the array at the
+// path whose keyField equals the raw key, or -1 when no element matches or
+// the array does not exist.
+func keyPosition(json, arrayPath, keyField, key string) (int, error) {
+	var arr gjson.Result
+	if arrayPath == "" {
+		arr = gjson.Parse(json)
+	} else {
+		arr = getPath(json, arrayPath)
+	}
+	if !arr.Exists() {
+		return -1, nil
+	}
+	if !arr.IsArray() {
+		return -1, &PathError{Path: arrayPath, Offset: arr.Index,
+			Err: ErrNotAnArray}
+	}
+	kres := gjson.Parse(key)
+	pos := -1
+	var i int
+	arr.ForEach(func(_, elem gjson.Result) bool {
+		if v := getPath(elem.Raw, keyField); v.Exists() && jsonEqual(v, kres) {
+			pos = i
+			return false
+		}
+		i++
+		return true
+	})
+	return pos, nil
+}
+
+// DeleteIf deletes a value for the specified path only when the predicate,
+// which is passed the root of the document, returns true. The json is
+// returned unchanged when it returns false.
+func DeleteIf(json, path string, predicate func(root gjson.Result) bool) (
+	string, error) {
+	if !predicate(gjson.Parse(json)) {
+		return json, nil
+	}
+	return Delete(json, path)
+}
+
+// DeleteIfAt deletes a value for the specified path only when the
+// predicate, which is passed the value at the condition path, returns true.
+// For example, "session.token" can be deleted when "session.expired" is
+// true. The value passed to the predicate does not exist when the condition
+// path does not exist.
+func DeleteIfAt(json, path, condPath string,
+	predicate func(value gjson.Result) bool) (string, error) {
+	if !predicate(getPath(json, condPath)) {
+		return json, nil
+	}
+	return Delete(json, path)
+}
+
+// Copy copies the value at the from path to the to path, replacing any
+// existing value. The value is copied as raw json, so that it's preserved
+// byte for byte. An error is returned when the from path does not exist.
+func Copy(json, from, to string) (string, error) {
+	res := getPath(json, from)
+	if !res.Exists() {
+		return json, &PathError{Path: from, Offset: -1, Err: ErrPathNotFound}
+	}
+	return SetRaw(json, to, res.Raw)
+}
+
+// RenameKey renames the object key at the specified path to newName. The
+// member keeps its position among the other members and its value is
+// preserved byte for byte. The newName is used literally, so it may contain
+// dots and other path characters.
+//
+// An error is returned when the path does not exist or is not an object
+// member, or when the object already has a newName key. With duplicate keys
+// the first matching key is renamed.
+func RenameKey(json, path, newName string) (string, error) {
+	if path == "" {
+		return json, ErrEmptyPath
+	}
+	paths, simple := splitPath(path)
+	if !simple {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	var parent gjson.Result
+	if len(paths) == 1 {
+		parent = gjson.Parse(json)
+		for ; parent.Index < len(json); parent.Index++ {
+			if json[parent.Index] > ' ' {
+				break
+			}
+		}
+	} else {
+		gpath := paths[0].gpart
+		for _, r := range paths[1 : len(paths)-1] {
+			gpath += "." + r.gpart
+		}
+		parent = gjson.Get(json, gpath)
+		if parent.Index == 0 {
+			return json, &PathError{Path: path, Offset: -1,
+				Err: ErrPathNotFound}
+		}
+	}
+	if parent.IsArray() {
+		return json, &PathError{Path: path, Offset: parent.Index,
+			Err: ErrInvalidPath}
+	}
+	name := paths[len(paths)-1].part
+	start, end := -1, -1
+	var exists bool
+	parent.ForEach(func(key, _ gjson.Result) bool {
+		k := key.String()
+		if k == name && start == -1 {
+			start, end = key.Index, key.Index+len(key.Raw)
+		} else if k == newName {
+			exists = true
+		}
+		return true
+	})
+	if start == -1 {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrPathNotFound}
+	}
+	if name == newName {
+		return json, nil
+	}
+	if exists {
+		return json, &PathError{Path: path, Offset: start, Err: ErrKeyExists}
+	}
+	buf := make([]byte, 0, len(json)+len(newName)+2-(end-start))
+	buf = append(buf, json[:start]...)
+	buf = appendStringify(buf, newName)
+	buf = append(buf, json[end:]...)
+	return string(buf), nil
+}
+
+// DeleteMatching deletes, in a single pass, every member of the object at
+// parentPath whose key matches the pattern. The pattern may contain the '*'
+// and '?' wildcard characters, such as "tmp_*". An empty parentPath is the
+// root object. The json is returned unchanged when no key matches, or when
+// the path does not exist or is not an object.
+func DeleteMatching(json, parentPath, pattern string) (string, error) {
+	var obj gjson.Result
+	if parentPath == "" {
+		obj = gjson.Parse(json)
+		for ; obj.Index < len(json); obj.Index++ {
+			if json[obj.Index] > ' ' {
+				break
+			}
+		}
+	} else {
+		obj = getPath(json, parentPath)
+	}
+	if !obj.IsObject() {
+		return json, nil
+	}
+	// the start and end of each member, and the ones that are kept
+	var starts, ends []int
+	var keep []bool
+	var deleted bool
+	obj.ForEach(func(key, value gjson.Result) bool {
+		starts = append(starts, key.Index)
+		ends = append(ends, value.Index+len(value.Raw))
+		matched := match.Match(key.String(), pattern)
+		keep = append(keep, !matched)
+		deleted = deleted || matched
+		return true
+	})
+	if !deleted {
+		return json, nil
+	}
+	last := len(starts) - 1
+	buf := make([]byte, 0, len(json))
+	buf = append(buf, json[:starts[0]]...)
+	var kept bool
+	for i := range starts {
+		if !keep[i] {
+			continue
+		}
+		if kept {
+			// the separator that preceded the member
+			buf = append(buf, json[ends[i-1]:starts[i]]...)
+		}
+		buf = append(buf, json[starts[i]:ends[i]]...)
+		kept = true
+	}
+	if !kept {
+		// nothing remains, drop the whitespace between the braces too
+		buf = buf[:obj.Index+1]
+	}
+	buf = append(buf, json[ends[last]:]...)
+	return string(buf), nil
+}
+
+// SetAndGetPrevious sets a json value for the specified path and returns the
+// value that it replaced, which does not exist when the path is new. The
+// previous value references the original json, so that it remains valid
+// after the set.
+func SetAndGetPrevious(json, path string, value interface{}) (string,
+	gjson.Result, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, gjson.Result{},
+			&PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := pathEdit(json, path, raw, stringify, del, nil)
+	if err == errNoChange {
+		return json, gjson.Result{}, nil
+	}
+	if err != nil {
+		return json, gjson.Result{}, err
+	}
+	var prev gjson.Result
+	if _, simple := splitPath(path); simple && !del {
+		if e.exists {
+			// the edit replaced the previous value
+			prev = gjson.Parse(json[e.start:e.end])
+			prev.Index = e.start
+		}
+	} else {
+		prev = getPath(json, path)
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), prev, nil
+}
+
+// SetIfEquals sets newValue at the specified path only when the current value
+// equals expected, which is useful for compare-and-set updates. The values
+// are compared as json, where numbers are compared by value, object members
+// are compared regardless of their order, and whitespace is ignored. A path
+// that does not exist is not equal to any value. The boolean return value
+// reports whether newValue was set.
+func SetIfEquals(json, path string, expected, newValue interface{}) (string,
+	bool, error) {
+	cur := getPath(json, path)
+	if !cur.Exists() {
+		return json, false, nil
+	}
+	raw, err := appendValue(nil, expected)
+	if err != nil {
+		return json, false, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	if !jsonEqual(cur, gjson.ParseBytes(raw)) {
+		return json, false, nil
+	}
+	res, err := Set(json, path, newValue)
+	if err != nil {
+		return json, false, err
+	}
+	return res, true, nil
+}
+
+// WouldChange reports whether setting the value at the specified path would
+// produce a different document, without building the new document when
+// possible. Documents are compared byte for byte, so replacing 1 with 1.0,
+// or an object with one that has the same members in a different order, is
+// a change.
+func WouldChange(json, path string, value interface{}) (bool, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return false, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := pathEdit(json, path, raw, stringify, del, nil)
+	if err == errNoChange {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if e.size(json) != len(json) {
+		return true, nil
+	}
+	if e.lead == 0 {
+		old := json[e.start:e.end]
+		if e.quote {
+			return len(old) < 2 || old[0] != '"' ||
+				old[1:len(old)-1] != e.mid, nil
+		}
+		return old != e.mid, nil
+	}
+	return string(e.append(make([]byte, 0, len(json)), json)) != json, nil
+}
+
+// SetAfter sets a json value for the specified path, and when a new member
+// is added to an existing object, it's inserted directly after the sibling
+// member named afterKey rather than at the end of the object. The new member
+// is appended when the object has no afterKey member, and an existing value
+// is replaced in place.
+func SetAfter(json, path, afterKey string, value interface{}) (string,
+	error) {
+	raw, stringify, _, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := setEdit(json, path, raw, stringify, false, nil)
+	if err != nil {
+		return json, err
+	}
+	if e.kind == editMember && !e.exists {
+		after := -1
+		gjson.Parse(json[e.open:]).ForEach(func(key, value gjson.Result) bool {
+			if key.String() == afterKey {
+				after = e.open + value.Index + len(value.Raw)
+				return false
+			}
+			return true
+		})
+		if after != -1 {
+			// the new member, without its comma and the closing brace
+			member := e.mid[:len(e.mid)-1]
+			if member[0] == ',' {
+				member = member[1:]
+			}
+			e = edit{start: after, end: after, mid: "," + member}
+		}
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), nil
+}
+
+// SetIfAbsent sets a json value for the specified path only when the path
+// does not already exist. An existing null value is considered present.
+// The boolean return value reports whether the value was set.
+func SetIfAbsent(json, path string, value interface{}) (string, bool, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, false, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := pathEdit(json, path, raw, stringify, del, nil)
+	if err == errNoChange || (err == nil && e.exists) {
+		return json, false, nil
+	}
+	if err != nil {
+		return json, false, err
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), true, nil
+}
+
+// EnsurePath sets defaultValue for the specified path only when the path does
+// not already exist, creating intermediate containers as needed. The created
+// return value reports whether the default was set. This is the same as
+// SetIfAbsent.
+func EnsurePath(json, path string, defaultValue interface{}) (result string,
+	created bool, err error) {
+	return SetIfAbsent(json, path, defaultValue)
+}
+
+// SetOutcome sets a json value for the specified path and reports whether
+// the path was created, or whether an existing value was updated. The
+// existence of the path is found while it's set, without reading it first.
+func SetOutcome(json, path string, value interface{}) (result string,
+	created bool, err error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, false, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := pathEdit(json, path, raw, stringify, del, nil)
+	if err == errNoChange {
+		return json, false, nil
+	}
+	if err != nil {
+		return json, false, err
+	}
+	return string(e.append(make([]byte, 0, e.size(json)), json)), !e.exists,
+		nil
+}
+
+// Add adds delta to the number at the specified path. When the path does not
+// exist the number is set to delta, and when the existing value is not a
+// number an error is returned. Integers remain integers when delta is a
+// whole number. An error is returned when delta or the sum is not finite.
+func Add(json, path string, delta float64) (string, error) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: &errorType{"unsupported value " +
+				strconv.FormatFloat(delta, 'g', -1, 64)}}
+	}
+	res := getPath(json, path)
+	var raw string
+	if !res.Exists() {
+		raw = strconv.FormatFloat(delta, 'f', -1, 64)
+	} else if res.Type != gjson.Number {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotANumber}
+	} else {
+		var ok bool
+		if raw, ok = addNumber(res, delta); !ok {
+			return json, &PathError{Path: path, Offset: res.Index,
+				Err: &errorType{"unsupported value " + raw}}
+		}
+	}
+	return SetRaw(json, path, raw)
+}
+
+// RoundSet sets the number at the specified path to value rounded to the
+// nearest multiple of step, such as 0.05. The number is written with no more
+// decimal places than step, so that it has no floating point noise. An
+// error is returned when step is not a positive number.
+func RoundSet(json, path string, value float64, step float64) (string,
+	error) {
+	if !(step > 0) || math.IsInf(step, 0) {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: &errorType{"step must be a positive number"}}
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: &errorType{"unsupported value " +
+				strconv.FormatFloat(value, 'g', -1, 64)}}
+	}
+	var places int
+	sstep := strconv.FormatFloat(step, 'f', -1, 64)
+	if i := strings.IndexByte(sstep, '.'); i != -1 {
+		places = len(sstep) - i - 1
+	}
+	f := math.Round(value/step) * step
+	f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'f', places, 64), 64)
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: &errorType{"unsupported value " +
+				strconv.FormatFloat(f, 'g', -1, 64)}}
+	}
+	if f == 0 {
+		// no negative zero
+		f = 0
+	}
+	return SetRaw(json, path, strconv.FormatFloat(f, 'f', -1, 64))
+}
+
+// Update sets the value at the specified path to the value returned by fn,
+// which is called with the current value. When the path does not exist, fn
+// is called with a result that does not exist, and the value is created.
+// The json is parsed once when the current value exists.
+func Update(json, path string, fn func(value gjson.Result) interface{}) (
+	string, error) {
+	if path == "" {
+		return json, ErrEmptyPath
+	}
+	res := getPath(json, path)
+	value := fn(res)
+	if res.Index == 0 {
+		return Set(json, path, value)
+	}
+	raw, stringify, _, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e := valueEdit(res.Index, res.Index+len(res.Raw), raw, stringify)
+	return string(e.append(make([]byte, 0, e.size(json)), json)), nil
+}
+
+// Toggle negates the boolean at the specified path, writing false for true
+// and true for false. An error is returned when the path does not exist or
+// when the existing value is not a boolean.
+func Toggle(json, path string) (string, error) {
+	return ToggleOptions(json, path, nil)
+}
+
+// BoolOptions represents additional options for the ToggleOptions function.
+type BoolOptions struct {
+	// Options are used for setting the boolean.
+	Options
+	// CreateMissing sets a missing value to true, rather than returning
+	// ErrPathNotFound.
+	CreateMissing bool
+}
+
+// ToggleOptions negates the boolean at the specified path with options.
+// With the CreateMissing option a missing value is set to true.
+func ToggleOptions(json, path string, bopts *BoolOptions) (string, error) {
+	var opts *Options
+	if bopts != nil {
+		opts = &bopts.Options
+	}
+	res := getPath(json, path)
+	raw := "true"
+	switch {
+	case !res.Exists():
+		if bopts == nil || !bopts.CreateMissing {
+			return json, &PathError{Path: path, Offset: -1,
+				Err: ErrPathNotFound}
+		}
+	case !res.IsBool():
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotABool}
+	case res.Bool():
+		raw = "false"
+	}
+	return SetRawOptions(json, path, raw, opts)
+}
+
+// Clear replaces the array or object at the specified path with an empty
+// array or object, keeping the key. An error is returned when the path does
+// not exist, or when its value is not an array or object.
+func Clear(json, path string) (string, error) {
+	res := getPath(json, path)
+	switch {
+	case !res.Exists():
+		return json, &PathError{Path: path, Offset: -1, Err: ErrPathNotFound}
+	case res.IsArray():
+		return SetRaw(json, path, "[]")
+	case res.IsObject():
+		return SetRaw(json, path, "{}")
+	}
+	return json, &PathError{Path: path, Offset: res.Index,
+		Err: ErrNotAContainer}
+}
+
+// AppendString appends the suffix to the string at the specified path. When
+// the path does not exist the string is set to the suffix, and when the
+// existing value is not a string an error is returned. The existing
+// characters of the string are preserved byte for byte, including their
+// escape sequences.
+func AppendString(json, path, suffix string) (string, error) {
+	res := getPath(json, path)
+	if !res.Exists() {
+		return Set(json, path, suffix)
+	}
+	if res.Type != gjson.String {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotAString}
+	}
+	// replace the closing quote with the escaped suffix, which is followed
+	// by its own closing quote
+	esuffix := appendStringify(nil, suffix)
+	raw := res.Raw[:len(res.Raw)-1] + string(esuffix[1:])
+	return SetRaw(json, path, raw)
+}
+
+// addNumber returns the sum of the number and delta as json. False is
+// returned, along with the sum formatted with 'g', when it is not finite.
+func addNumber(res gjson.Result, delta float64) (string, bool) {
+	if delta == math.Trunc(delta) && math.Abs(delta) < 1<<53 {
+		n, err := strconv.ParseInt(res.Raw, 10, 64)
+		if err == nil {
+			d := int64(delta)
+			if (d > 0 && n <= math.MaxInt64-d) ||
+				(d <= 0 && n >= math.MinInt64-d) {
+				return strconv.FormatInt(n+d, 10), true
+			}
+		}
+	}
+	sum := res.Num + delta
+	if math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return strconv.FormatFloat(sum, 'g', -1, 64), false
+	}
+	return strconv.FormatFloat(sum, 'f', -1, 64), true
+}
+
+// SetAll sets a value at every array element matched by the "#" or query
+// components of the path. For example, "friends.#.active" sets the "active"
+// field of every friend, and "friends.#(age>40)#.flagged" sets the "flagged"
+// field of the friends that match the query. Unlike Set, the fields are
+// created when they do not exist.
+// The json is returned unchanged when the array does not exist.
+func SetAll(json, path string, value interface{}) (string, error) {
+	raw, stringify, del, err := valueRaw(value, nil)
+	if err != nil {
+		return json, &PathError{Path: path, Offset: -1, Err: err}
+	}
+	return setAll(json, path, raw, stringify, del, false)
+}
+
+// setAll sets the value at every matched path. When existing is set, only
+// the values that already exist are set.
+func setAll(json, path, raw string, stringify, del,
+	existing bool) (string, error) {
+	paths, err := matchPaths(json, path, existing, nil)
+	if err != nil {
+		return json, err
+	}
+	// set in descending order so the earlier positions remain valid
+	res := json
+	for i := len(paths) - 1; i >= 0; i-- {
+		b, err := set(res, paths[i], raw, stringify, del, nil)
+		if err == errNoChange {
+			continue
+		}
+		if err != nil {
+			return json, err
+		}
+		res = string(b)
+	}
+	return res, nil
+}
+
+// MatchPaths returns the concrete paths that SetAll sets for the path, with
+// every "#" and query component expanded to the positions of the matched
+// array elements. For example, "friends.#.last" returns "friends.0.last",
+// "friends.1.last", and so on. The paths are in document order and may be
+// used with Set. Like SetAll, the last components of a path may not exist
+// yet, and no paths are returned when an array does not exist.
+func MatchPaths(json, path string) ([]string, error) {
+	if path == "" {
+		return nil, ErrEmptyPath
+	}
+	paths, err := matchPaths(json, path, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// matchPaths appends the paths matched by the path to paths. When existing
+// is set, only the paths of values that already exist are matched.
+func matchPaths(json, path string, existing bool,
+	paths []string) ([]string, error) {
+	start, end := wildcardComponent(path)
+	if start == -1 {
+		if existing && !getPath(json, path).Exists() {
+			return paths, nil
+		}
+		return append(paths, path), nil
+	}
+	var apath, rest string
+	if start > 0 {
+		apath = path[:start-1]
+	}
+	if end < len(path) {
+		rest = path[end+1:]
+	}
+	comp := path[start:end]
+	var arr gjson.Result
+	if apath == "" {
+		arr = gjson.Parse(json)
+	} else {
+		arr = getPath(json, apath)
+	}
+	if !arr.Exists() || (existing && !arr.IsArray()) {
+		return paths, nil
+	}
+	if !arr.IsArray() {
+		return paths, &PathError{Path: path, Offset: arr.Index,
+			Err: ErrNotAnArray}
+	}
+	var positions []int
+	if comp == "#" {
+		positions = make([]int, len(arrayElements(arr)))
+		for i := range positions {
+			positions[i] = i
+		}
+	} else {
+		var err error
+		_, positions, err = queryPositions(json, joinPath(apath, comp))
+		if err != nil {
+			return paths, err
+		}
+		if comp[len(comp)-1] != '#' && len(positions) > 1 {
+			// only the first match
+			positions = positions[:1]
+		}
+	}
+	for _, pos := range positions {
+		epath := joinPath(apath, strconv.Itoa(pos))
+		if rest != "" {
+			epath += "." + rest
+		}
+		var err error
+		paths, err = matchPaths(json, epath, existing, paths)
+		if err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
+
+// Redact sets the value at each of the paths to the mask, such as "***",
+// leaving the structure of the json intact. The paths may have "#" and
+// query components, such as "users.#.password", which mask the value in
+// every matched element. Paths that do not exist are skipped, and no new
+// values are created.
+func Redact(json string, paths []string, mask interface{}) (string, error) {
+	raw, stringify, _, err := valueRaw(mask, nil)
+	if err != nil {
+		return json, err
+	}
+	res := json
+	for _, path := range paths {
+		if path == "" {
+			return json, ErrEmptyPath
+		}
+		res, err = setAll(res, path, raw, stringify, false, true)
+		if err != nil {
+			return json, err
+		}
+	}
+	return res, nil
+}
+
+// wildcardComponent returns the start and end of the first "#" or query
+// component in the path, or -1 when there is none.
+func wildcardComponent(path string) (start, end int) {
+	var depth int
+	for i := 0; i <= len(path); i++ {
+		if i == len(path) || (path[i] == '.' && depth == 0) {
+			comp := path[start:i]
+			if comp == "#" || strings.HasPrefix(comp, "#(") {
+				return start, i
+			}
+			start = i + 1
+			continue
+		}
+		switch path[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+	}
+	return -1, -1
+}
+
+// queryPositions returns the path to the array queried by the path, and the
+// positions of the elements in that array that match the query. The path
+// must end with a query component.
+func queryPositions(jstr, path string) (apath string, positions []int,
+	err error) {
+	i := strings.Index(path, ".#(")
+	if i == -1 {
+		if !strings.HasPrefix(path, "#(") {
+			return "", nil, &PathError{Path: path, Offset: -1,
+				Err: ErrInvalidPath}
+		}
+		i = 0
+	} else {
+		apath = path[:i]
+		i++
+	}
+	query := path[i:]
+	if strings.HasSuffix(query, ")") {
+		query += "#"
+	} else if !strings.HasSuffix(query, ")#") {
+		return "", nil, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	var arr gjson.Result
+	if apath == "" {
+		arr = gjson.Parse(jstr)
+	} else {
+		arr = getPath(jstr, apath)
+		query = gjsonPath(apath) + "." + query
+	}
+	if !arr.IsArray() {
+		return "", nil, &PathError{Path: path, Offset: arr.Index,
+			Err: ErrNotAnArray}
+	}
+	matches := gjson.Get(jstr, query).Indexes
+	if len(matches) == 0 {
+		return apath, nil, nil
+	}
+	var j int
+	for pos, elem := range arrayElements(arr) {
+		for ; j < len(matches) && matches[j] < elem.Index; j++ {
+		}
+		if j == len(matches) {
+			break
+		}
+		if matches[j] == elem.Index {
+			positions = append(positions, pos)
+		}
+	}
+	return apath, positions, nil
+}
+
+// joinPath joins two path components, where the first may be empty.
+func joinPath(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "." + b
+}
+
+// Append appends values to the end of the array at the specified path.
+// If the path does not exist then a new array is created, and if the
+// existing value is not an array then an error is returned.
+//
+// This is clearer than using the "-1" key in a path, and allows for
+// appending many values at once.
+func Append(json, path string, values ...interface{}) (string, error) {
+	res, err := AppendBytes(stringBytes(json), path, values...)
+	return string(res), err
+}
+
+// AppendBytes appends values to the end of the array at the specified path.
+// If working with bytes, this method preferred over
+// Append(string(data), path, values...)
+func AppendBytes(json []byte, path string, values ...interface{}) ([]byte, error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	var raw []byte
+	for i, value := range values {
+		if i > 0 {
+			raw = append(raw, ',')
+		}
+		var err error
+		raw, err = appendValue(raw, value)
+		if err != nil {
+			return json, err
+		}
+	}
+	res := getPath(jstr, path)
+	if !res.Exists() {
+		raw = append(append([]byte{'['}, raw...), ']')
+		return SetRawBytes(json, path, raw)
+	}
+	if !res.IsArray() {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotAnArray}
+	}
+	if res.Index == 0 {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	if !containerClosed(res.Raw) {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrInvalidJSON}
+	}
+	if len(values) == 0 {
+		return json, nil
+	}
+	end := len(res.Raw) - 1
+	for ; end > 0; end-- {
+		if res.Raw[end] == ']' {
+			break
+		}
+	}
+	var mid []byte
+	if len(trim(res.Raw[1:end])) > 0 {
+		mid = append(mid, ',')
+	}
+	mid = append(mid, raw...)
+	e := edit{start: res.Index + end, end: res.Index + end,
+		mid: *(*string)(unsafe.Pointer(&mid))}
+	return e.append(make([]byte, 0, e.size(jstr)), jstr), nil
+}
+
+// Insert inserts a value into the array at the specified path, ahead of the
+// element currently at index, shifting the following elements to the right.
+// A negative index counts from the end of the array, and an index that is
+// equal to the length of the array appends the value. A path that does not
+// exist is treated as an empty array.
+// An error is returned if the existing value is not an array or if the
+// index is out of range.
+func Insert(json, path string, index int, value interface{}) (string, error) {
+	res, err := InsertBytes(stringBytes(json), path, index, value)
+	return string(res), err
+}
+
+// InsertBytes inserts a value into the array at the specified path.
+// If working with bytes, this method preferred over
+// Insert(string(data), path, index, value)
+func InsertBytes(json []byte, path string, index int, value interface{}) ([]byte, error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	res := getPath(jstr, path)
+	if res.Exists() && !res.IsArray() {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotAnArray}
+	}
+	elems := arrayElements(res)
+	if index < 0 {
+		index += len(elems)
+	}
+	if index < 0 || index > len(elems) {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrIndexOutOfRange}
+	}
+	if index == len(elems) {
+		return AppendBytes(json, path, value)
+	}
+	if res.Index == 0 {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	raw, err := appendValue(nil, value)
+	if err != nil {
+		return json, err
+	}
+	return arrayInsertRaw(jstr, elems[index], string(raw)), nil
+}
+
+// TrimArray deletes elements from the front of the array at the specified
+// path, so that only the last keepLast elements remain. This bounds the size
+// of an array that's used as a ring buffer.
+// A path that does not exist is left unchanged, and an error is returned if
+// the existing value is not an array or if keepLast is negative.
+func TrimArray(json, path string, keepLast int) (string, error) {
+	if keepLast < 0 {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: ErrIndexOutOfRange}
+	}
+	n := int(getPath(json, path).Get("#").Int())
+	return SliceArray(json, path, n-keepLast, n)
+}
+
+// TrimArrayFirst deletes elements from the end of the array at the
+// specified path, so that only the first keepFirst elements remain.
+// A path that does not exist is left unchanged, and an error is returned if
+// the existing value is not an array or if keepFirst is negative.
+func TrimArrayFirst(json, path string, keepFirst int) (string, error) {
+	if keepFirst < 0 {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: ErrIndexOutOfRange}
+	}
+	return SliceArray(json, path, 0, keepFirst)
+}
+
+// SliceArray deletes the elements of the array at the specified path that
+// are outside of the window from start up to, but not including, end, in a
+// single pass. The kept elements are preserved byte for byte. A negative
+// start is treated as zero and an end past the length of the array is
+// treated as the length.
+// A path that does not exist is left unchanged, and an error is returned if
+// the existing value is not an array or if start is greater than end.
+func SliceArray(json, path string, start, end int) (string, error) {
+	if start > end {
+		return json, &PathError{Path: path, Offset: -1,
+			Err: ErrIndexOutOfRange}
+	}
+	res := getPath(json, path)
+	if !res.Exists() {
+		return json, nil
+	}
+	if !res.IsArray() {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotAnArray}
+	}
+	if res.Index == 0 {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	elems := arrayElements(res)
+	if start < 0 {
+		start = 0
+	}
+	if end > len(elems) {
+		end = len(elems)
+	}
+	if start == 0 && end == len(elems) {
+		return json, nil
+	}
+	buf := make([]byte, 0, len(json))
+	buf = append(buf, json[:res.Index]...)
+	if start >= end {
+		buf = append(buf, '[', ']')
+	} else {
+		// keep the whitespace that surrounds the elements
+		first, last := elems[0], elems[len(elems)-1]
+		buf = append(buf, json[res.Index:first.Index]...)
+		buf = append(buf, json[elems[start].Index:elems[end-1].Index+
+			len(elems[end-1].Raw)]...)
+		buf = append(buf, json[last.Index+len(last.Raw):res.Index+
+			len(res.Raw)]...)
+	}
+	buf = append(buf, json[res.Index+len(res.Raw):]...)
+	return string(buf), nil
+}
+
+// SwapElements swaps the elements at indexes i and j of the array at the
+// specified path. Negative indexes count from the end of the array. The
+// elements are preserved byte for byte.
+// An error is returned if the path does not exist, if the existing value is
+// not an array, or if an index is out of range.
+func SwapElements(json, path string, i, j int) (string, error) {
+	return reorderElements(json, path, i, j, func(order []int, i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+}
+
+// MoveElement moves the element at index from of the array at the specified
+// path to index to, shifting the elements in between. Negative indexes count
+// from the end of the array. The elements are preserved byte for byte.
+// An error is returned if the path does not exist, if the existing value is
+// not an array, or if an index is out of range.
+func MoveElement(json, path string, from, to int) (string, error) {
+	return reorderElements(json, path, from, to, func(order []int, from,
+		to int) {
+		elem := order[from]
+		if from < to {
+			copy(order[from:], order[from+1:to+1])
+		} else {
+			copy(order[to+1:], order[to:from])
+		}
+		order[to] = elem
+	})
+}
+
+// reorderElements rewrites the array at the path with its elements in the
+// order made by the reorder function, which is passed the positions of the
+// elements and the two indexes. The whitespace between the elements is
+// kept in place.
+func reorderElements(json, path string, i, j int,
+	reorder func(order []int, i, j int)) (string, error) {
+	res := getPath(json, path)
+	if !res.Exists() {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrPathNotFound}
+	}
+	if !res.IsArray() {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrNotAnArray}
+	}
+	if res.Index == 0 {
+		return json, &PathError{Path: path, Offset: -1, Err: ErrInvalidPath}
+	}
+	elems := arrayElements(res)
+	if i < 0 {
+		i += len(elems)
+	}
+	if j < 0 {
+		j += len(elems)
+	}
+	if i < 0 || i >= len(elems) || j < 0 || j >= len(elems) {
+		return json, &PathError{Path: path, Offset: res.Index,
+			Err: ErrIndexOutOfRange}
+	}
+	if i == j {
+		return json, nil
+	}
+	order := make([]int, len(elems))
+	for k := range order {
+		order[k] = k
+	}
+	reorder(order, i, j)
+	first, last := elems[0], elems[len(elems)-1]
+	buf := make([]byte, 0, len(json))
+	buf = append(buf, json[:first.Index]...)
+	for k, pos := range order {
+		if k > 0 {
+			prev := elems[k-1]
+			buf = append(buf, json[prev.Index+len(prev.Raw):elems[k].Index]...)
+		}
+		buf = append(buf, elems[pos].Raw...)
+	}
+	buf = append(buf, json[last.Index+len(last.Raw):]...)
+	return string(buf), nil
+}
+
+// arrayElements returns the elements of an array. Unlike Result.Array, the
+// Index of each element is its position in the original json.
+func arrayElements(res gjson.Result) []gjson.Result {
+	var elems []gjson.Result
+	res.ForEach(func(_, value gjson.Result) bool {
+		elems = append(elems, value)
+		return true
+	})
+	return elems
+}
+
+// appendValue appends the json representation of a value to buf.
+func appendValue(buf []byte, value interface{}) ([]byte, error) {
+	raw, stringify, _, err := valueRaw(value, nil)
+	if err != nil {
+		return buf, err
+	}
+	if stringify {
+		return appendStringify(buf, raw), nil
+	}
+	return append(buf, raw...), nil
+}
+
+// stringBytes returns the bytes of a string without copying. The bytes
+// must not be modified.
+func stringBytes(s string) []byte {
+	sh := *(*stringHeader)(unsafe.Pointer(&s))
+	bh := sliceHeader{data: sh.data, len: sh.len, cap: sh.len}
+	return *(*[]byte)(unsafe.Pointer(&bh))
+}
+
+type stringHeader struct {
+	data unsafe.Pointer
+	len  int
+}
+
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+func setByGetResult(jstr, raw string, res gjson.Result,
+	stringify, del, optimistic, inplace bool) ([]byte, error) {
+
+	sz := len(jstr) - len(res.Raw) + len(raw)
+	if stringify {
+		sz += 2
+	}
+	if inplace && sz <= len(jstr) {
+		if !stringify || !mustMarshalString(raw) {
+			jsonh := *(*stringHeader)(unsafe.Pointer(&jstr))
+			jsonbh := sliceHeader{
+				data: jsonh.data, len: jsonh.len, cap: jsonh.len}
+			jbytes := *(*[]byte)(unsafe.Pointer(&jsonbh))
+			if stringify {
+				jbytes[res.Index] = '"'
+				copy(jbytes[res.Index+1:], []byte(raw))
+				jbytes[res.Index+1+len(raw)] = '"'
+				copy(jbytes[res.Index+1+len(raw)+1:],
+					jbytes[res.Index+len(res.Raw):])
+			} else {
+				copy(jbytes[res.Index:], []byte(raw))
+				copy(jbytes[res.Index+len(raw):],
+					jbytes[res.Index+len(res.Raw):])
+			}
+			return jbytes, nil
+		}
+		return []byte(jstr), nil
+	}
+	buf := make([]byte, 0, sz)
+	buf = append(buf, jstr[:res.Index]...)
+	if stringify {
+		buf = appendStringify(buf, raw)
+	} else {
+		buf = append(buf, raw...)
+	}
+	buf = append(buf, jstr[res.Index+len(res.Raw):]...)
+	return buf, nil
+}
+
+func setManyByGetResult(jstr string, raws []interface{}, valueDiff int, ress []gjson.Result,
+	stringify, inplace bool) ([]byte, error) {
+
+	var blen int
+
+	if stringify {
+		valueDiff += 2 * len(raws)
+	}
+
+	blen = len(jstr) + valueDiff
+	var buf = make([]byte, len(jstr))
+	copy(buf, jstr)
+	if !inplace {
+		return nil, fmt.Errorf("not supported if replace is not inplace")
+	}
+	jsonh := *(*stringHeader)(unsafe.Pointer(&jstr))
+	jsonbh := sliceHeader{
+		data: jsonh.data, len: blen, cap: blen}
+	jbytes := *(*[]byte)(unsafe.Pointer(&jsonbh))
+	var rwb []byte
+	var diff int
+	for i := 0; i < len(ress); i++ {
+		raw := raws[i]
+		res := ress[i]
+		rwb = getBytes(raw)
+		var currentSz int
+
+		currentSz = len(rwb) - len(res.Raw)
+
+		if stringify {
+			currentSz += 2
+
+			jbytes[res.Index+diff] = '"'
+			copy(jbytes[res.Index+1+diff:], rwb) // 1 index
+			jbytes[res.Index+1+len(rwb)+diff] = '"'
+			if i+1 < len(ress) {
+				copy(jbytes[res.Index+1+len(rwb)+1+diff:],
+					buf[res.Index+len(res.Raw):ress[i+1].Index]) // next index, copy from index + len + till next index
+			} else {
+				copy(jbytes[res.Index+1+len(rwb)+1+diff:], // last index
+					buf[res.Index+len(res.Raw):])
+			}
+			diff += currentSz
 		} else {
 			copy(jbytes[res.Index+diff:], rwb) // 1 index
 			if i+1 < len(ress) {
@@ -594,130 +3016,551 @@ func setManyByGetResult(jstr string, raws []interface{}, valueDiff int, ress []g
 				copy(jbytes[res.Index+len(rwb)+diff:], // last index
 					buf[res.Index+len(res.Raw):])
 			}
-			diff += currentSz
+			diff += currentSz
+		}
+	}
+	return jbytes, nil
+}
+
+func set(jstr, path, raw string, stringify, del bool,
+	opts *Options) ([]byte, error) {
+	var inplace bool
+	if opts != nil {
+		inplace = opts.ReplaceInPlace
+	}
+	e, err := setEdit(jstr, path, raw, stringify, del, opts)
+	if err != nil {
+		return []byte(jstr), err
+	}
+	return e.build(jstr, inplace), nil
+}
+
+// build returns the new document. When inplace is set, an optimistic edit
+// that fits reuses the bytes of jstr.
+func (e *edit) build(jstr string, inplace bool) []byte {
+	sz := e.size(jstr)
+	if inplace && e.optimistic && sz <= len(jstr) {
+		jbytes := *(*[]byte)(unsafe.Pointer(&sliceHeader{
+			data: (*stringHeader)(unsafe.Pointer(&jstr)).data,
+			len:  len(jstr), cap: len(jstr)}))
+		e.appendMid(jbytes[:e.start])
+		copy(jbytes[sz-(len(jstr)-e.end):], jbytes[e.end:])
+		return jbytes[:sz]
+	}
+	return e.append(make([]byte, 0, sz), jstr)
+}
+
+// setEdit returns the edit for setting or deleting the path in jstr.
+func setEdit(jstr, path, raw string, stringify, del bool,
+	opts *Options) (edit, error) {
+	if opts != nil && opts.MaxInputBytes > 0 && len(jstr) > opts.MaxInputBytes {
+		return edit{}, &PathError{Path: path, Offset: -1,
+			Err: ErrInputTooLarge}
+	}
+	e, err := pathEdit(jstr, path, raw, stringify, del, opts)
+	if err != nil || opts == nil {
+		return e, err
+	}
+	if opts.MaxDepth > 0 && !e.exists && pathDepth(path) > opts.MaxDepth {
+		return edit{}, &PathError{Path: path, Offset: -1, Err: ErrMaxDepth}
+	}
+	if opts.PreserveIndent {
+		e = indentEdit(jstr, e)
+	}
+	if opts.PreserveTrailingNewline {
+		e = newlineEdit(jstr, e)
+	}
+	if opts.ValidateResult &&
+		!gjson.ValidBytes(e.append(make([]byte, 0, e.size(jstr)), jstr)) {
+		return edit{}, &PathError{Path: path, Offset: e.start,
+			Err: ErrInvalidJSON}
+	}
+	if opts.PostEditHook != nil {
+		res := formatResult(e.append(make([]byte, 0, e.size(jstr)), jstr), opts)
+		if err := opts.PostEditHook(res); err != nil {
+			return edit{}, err
+		}
+	}
+	return e, nil
+}
+
+// badEscape returns the index of the first backslash in the path that does
+// not escape a punctuation character, or -1 when there is none.
+func badEscape(path string) int {
+	for i := 0; i < len(path); i++ {
+		if path[i] != '\\' {
+			continue
+		}
+		if i+1 == len(path) {
+			return i
+		}
+		c := path[i+1]
+		if c <= ' ' || c > '~' || (c >= 'a' && c <= 'z') ||
+			(c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// pathDepth returns the number of components in the path.
+func pathDepth(path string) int {
+	n, depth := 1, 0
+	for i := 0; i < len(path); i++ {
+		switch path[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '.':
+			if depth == 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// newlineEdit returns the edit with the newline at the end of jstr, "\n"
+// or "\r\n", kept at the end of the new document.
+func newlineEdit(jstr string, e edit) edit {
+	nl := "\n"
+	if strings.HasSuffix(jstr, "\r\n") {
+		nl = "\r\n"
+	} else if !strings.HasSuffix(jstr, nl) {
+		return e
+	}
+	if e.end <= len(jstr)-len(nl) {
+		return e
+	}
+	e.end = len(jstr)
+	e.mid += nl
+	return e
+}
+
+func pathEdit(jstr, path, raw string, stringify, del bool,
+	opts *Options) (edit, error) {
+	var optimistic bool
+	if opts != nil {
+		optimistic = opts.Optimistic
+	}
+	if path == "" {
+		return edit{}, ErrEmptyPath
+	}
+	if opts != nil && opts.StrictPathEscaping {
+		if i := badEscape(path); i != -1 {
+			return edit{}, &PathError{Path: path, Offset: -1,
+				Err: fmt.Errorf("%w: bad escape at index %d", ErrInvalidPath,
+					i)}
+		}
+	}
+	if strings.HasPrefix(jstr, bom) {
+		// the byte order mark is not part of the json value
+		e, err := pathEdit(jstr[len(bom):], path, raw, stringify, del, opts)
+		if err != nil {
+			return e, err
+		}
+		e.start += len(bom)
+		e.end += len(bom)
+		e.open += len(bom)
+		if opts != nil && opts.StripBOM {
+			e.lead += len(bom)
+		} else {
+			e.lead = 0
+		}
+		return e, nil
+	}
+	if !del && optimistic && opts.DuplicateKey == 0 &&
+		isOptimisticPath(path) {
+		res := gjson.Get(jstr, path)
+		if res.Exists() && res.Index > 0 {
+			e := valueEdit(res.Index, res.Index+len(res.Raw), raw, stringify)
+			e.optimistic, e.exists = true, true
+			return e, nil
+		}
+	}
+	if (opts == nil || opts.DuplicateKey == 0) && isTopLevelKey(path) {
+		e, err := topLevelEdit(jstr, path, raw, stringify, del)
+		if err != errNotTopLevel {
+			return e, err
+		}
+	}
+	paths, simple := splitPath(path)
+	if del && simple && opts != nil && opts.PruneEmptyParents {
+		paths = pruneParents(jstr, paths)
+	}
+	return pathsEdit(jstr, path, paths, simple, raw, stringify, del, opts)
+}
+
+var errNotTopLevel = &errorType{"not a top-level object"}
+
+// isTopLevelKey returns true if the path is a single key that needs no
+// unescaping, which is used literally when the json is an object.
+func isTopLevelKey(path string) bool {
+	if path[0] == ':' {
+		return false
+	}
+	for i := 0; i < len(path); i++ {
+		if path[i] == '.' || path[i] == '\\' || !isSimpleChar(path[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// topLevelEdit returns the edit for setting or deleting a member of the
+// top-level object by scanning the object directly. The errNotTopLevel
+// error is returned when the json is not an object that can be scanned,
+// which is left to the general path engine.
+func topLevelEdit(jstr, key, raw string, stringify, del bool) (edit, error) {
+	i := 0
+	for ; i < len(jstr) && jstr[i] <= ' '; i++ {
+	}
+	if i == len(jstr) || jstr[i] != '{' {
+		return edit{}, errNotTopLevel
+	}
+	open := i
+	var members bool
+	for i++; i < len(jstr); i++ {
+		if jstr[i] <= ' ' {
+			continue
+		}
+		if jstr[i] == '}' {
+			break
+		}
+		if jstr[i] != '"' {
+			return edit{}, errNotTopLevel
+		}
+		// the member key
+		members = true
+		ks, esc := i+1, false
+		for i++; i < len(jstr) && jstr[i] != '"'; i++ {
+			if jstr[i] == '\\' {
+				esc = true
+				i++
+			}
+		}
+		if i >= len(jstr) {
+			return edit{}, errNotTopLevel
+		}
+		match := jstr[ks:i] == key
+		if esc {
+			match = gjson.Parse(jstr[ks-1:i+1]).Str == key
+		}
+		for i++; i < len(jstr) && jstr[i] <= ' '; i++ {
+		}
+		if i == len(jstr) || jstr[i] != ':' {
+			return edit{}, errNotTopLevel
+		}
+		for i++; i < len(jstr) && jstr[i] <= ' '; i++ {
+		}
+		// the member value
+		vs := i
+		if i += valueLen(jstr[i:]); i == vs {
+			return edit{}, errNotTopLevel
+		}
+		if match {
+			if del {
+				start, end := deleteRange(jstr, vs, i-vs)
+				return edit{start: start, end: end, exists: true}, nil
+			}
+			e := valueEdit(vs, i, raw, stringify)
+			e.exists = true
+			return e, nil
+		}
+		for ; i < len(jstr) && jstr[i] <= ' '; i++ {
+		}
+		if i == len(jstr) || (jstr[i] != ',' && jstr[i] != '}') {
+			return edit{}, errNotTopLevel
+		}
+		if jstr[i] == '}' {
+			break
+		}
+	}
+	if i >= len(jstr) {
+		return edit{}, errNotTopLevel
+	}
+	if del {
+		return edit{}, errNoChange
+	}
+	// add the member to the end of the object
+	var buf []byte
+	if members {
+		buf = append(buf, ',')
+	}
+	buf = appendStringify(buf, key)
+	buf = append(buf, ':')
+	if stringify {
+		buf = appendStringify(buf, raw)
+	} else {
+		buf = append(buf, raw...)
+	}
+	buf = append(buf, '}')
+	return edit{lead: open, start: i, end: len(jstr),
+		mid: *(*string)(unsafe.Pointer(&buf)), kind: editMember,
+		open: open}, nil
+}
+
+// valueLen returns the length of the json value at the start of json, or
+// zero when there's no value or it's not closed.
+func valueLen(json string) int {
+	var depth int
+	for i := 0; i < len(json); i++ {
+		switch json[i] {
+		case '"':
+			for i++; i < len(json) && json[i] != '"'; i++ {
+				if json[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(json) {
+				return 0
+			}
+			if depth == 0 {
+				return i + 1
+			}
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return i
+			}
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		case ',', ' ', '\t', '\n', '\r':
+			if depth == 0 {
+				return i
+			}
 		}
 	}
-	return jbytes, nil
+	if depth > 0 {
+		return 0
+	}
+	return len(json)
 }
 
-func set(jstr, path, raw string,
-	stringify, del, optimistic, inplace bool) ([]byte, error) {
-	if path == "" {
-		return []byte(jstr), &errorType{"path cannot be empty"}
-	}
-	if !del && optimistic && isOptimisticPath(path) {
-		res := gjson.Get(jstr, path)
-		if res.Exists() && res.Index > 0 {
-			sz := len(jstr) - len(res.Raw) + len(raw)
-			if stringify {
-				sz += 2
-			}
-			if inplace && sz <= len(jstr) {
-				if !stringify || !mustMarshalString(raw) {
-					jsonh := *(*stringHeader)(unsafe.Pointer(&jstr))
-					jsonbh := sliceHeader{
-						data: jsonh.data, len: jsonh.len, cap: jsonh.len}
-					jbytes := *(*[]byte)(unsafe.Pointer(&jsonbh))
-					if stringify {
-						jbytes[res.Index] = '"'
-						copy(jbytes[res.Index+1:], []byte(raw))
-						jbytes[res.Index+1+len(raw)] = '"'
-						copy(jbytes[res.Index+1+len(raw)+1:],
-							jbytes[res.Index+len(res.Raw):])
-					} else {
-						copy(jbytes[res.Index:], []byte(raw))
-						copy(jbytes[res.Index+len(raw):],
-							jbytes[res.Index+len(res.Raw):])
-					}
-					return jbytes[:sz], nil
-				}
-				return []byte(jstr), nil
+// pruneParents drops the trailing components of the paths while the deleted
+// value is the only child of its parent, so that the parent is deleted
+// instead.
+func pruneParents(jstr string, paths []pathResult) []pathResult {
+	for len(paths) > 1 {
+		parent := gjson.Parse(jstr)
+		for _, r := range paths[:len(paths)-1] {
+			if n, ok := negativeIndex(r); ok && parent.IsArray() {
+				r.gpart = strconv.Itoa(int(parent.Get("#").Int()) + n)
 			}
-			buf := make([]byte, 0, sz)
-			buf = append(buf, jstr[:res.Index]...)
-			if stringify {
-				buf = appendStringify(buf, raw)
-			} else {
-				buf = append(buf, raw...)
+			parent = parent.Get(r.gpart)
+		}
+		if !parent.IsObject() && !parent.IsArray() {
+			break
+		}
+		var n int
+		parent.ForEach(func(_, _ gjson.Result) bool {
+			n++
+			return n < 2
+		})
+		last := paths[len(paths)-1]
+		if n != 1 {
+			break
+		}
+		if parent.IsArray() {
+			// the only element is at 0 or -1
+			n, ok := negativeIndex(last)
+			if (!ok || n != -1) && (last.force || last.part != "0") {
+				break
 			}
-			buf = append(buf, jstr[res.Index+len(res.Raw):]...)
-			return buf, nil
+		} else if !parent.Get(last.gpart).Exists() {
+			break
 		}
+		paths = paths[:len(paths)-1]
 	}
-	var paths []pathResult
+	return paths
+}
+
+// splitPath splits the path into its components. The components are only
+// returned when the path is simple, without wildcards, queries or modifiers.
+func splitPath(path string) (paths []pathResult, simple bool) {
 	r, simple := parsePath(path)
 	if simple {
 		paths = append(paths, r)
 		for r.more {
 			r, simple = parsePath(r.path)
 			if !simple {
-				break
+				return nil, false
 			}
 			paths = append(paths, r)
 		}
 	}
+	return paths, simple
+}
+
+// pathsEdit returns the edit for setting or deleting a path that has already
+// been split into its components.
+func pathsEdit(jstr, path string, paths []pathResult, simple bool, raw string,
+	stringify, del bool, opts *Options) (edit, error) {
 	if !simple {
 		if del {
-			return []byte(jstr),
-				&errorType{"cannot delete value from a complex path"}
+			return edit{}, &PathError{Path: path, Offset: -1,
+				Err: ErrInvalidPath}
 		}
-		return setComplexPath(jstr, path, raw, stringify)
+		return complexPathEdit(jstr, path, raw, stringify)
 	}
-	njson, err := appendRawPaths(nil, jstr, paths, raw, stringify, del)
-	if err != nil {
-		return []byte(jstr), err
+	e, err := rawPathsEdit(jstr, 0, paths, raw, stringify, del, opts)
+	if perr, ok := err.(*PathError); ok {
+		perr.Path = path
 	}
-	return njson, nil
+	return e, err
 }
 
-func setComplexPath(jstr, path, raw string, stringify bool) ([]byte, error) {
-	res := gjson.Get(jstr, path)
-	if !res.Exists() || !(res.Index != 0 || len(res.Indexes) != 0) {
-		return []byte(jstr), errNoChange
-	}
-	if res.Index != 0 {
-		njson := []byte(jstr[:res.Index])
-		if stringify {
-			njson = appendStringify(njson, raw)
-		} else {
-			njson = append(njson, raw...)
+// complexPathEdit returns the edit for setting every value matched by a
+// complex path, such as a query. The replaced region spans from the first
+// to the last matched value.
+func complexPathEdit(jstr, path, raw string, stringify bool) (edit, error) {
+	var vals []gjson.Result
+	if comps, err := ParsePath(path); err == nil && hasArrayComponent(comps) {
+		// the values are matched one component at a time, so that queries
+		// may be chained
+		root := gjson.Parse(jstr)
+		for ; root.Index < len(jstr); root.Index++ {
+			if jstr[root.Index] > ' ' {
+				break
+			}
 		}
-		njson = append(njson, jstr[res.Index+len(res.Raw):]...)
-		jstr = string(njson)
-	}
-	if len(res.Indexes) > 0 {
-		type val struct {
-			index int
-			res   gjson.Result
+		vals = matchComponents(root, comps, nil)
+	} else {
+		res := gjson.Get(jstr, path)
+		if !res.Exists() || !(res.Index != 0 || len(res.Indexes) != 0) {
+			return edit{}, errNoChange
+		}
+		if res.Index != 0 {
+			e := valueEdit(res.Index, res.Index+len(res.Raw), raw, stringify)
+			e.exists = true
+			return e, nil
 		}
-		vals := make([]val, 0, len(res.Indexes))
 		res.ForEach(func(_, vres gjson.Result) bool {
-			vals = append(vals, val{res: vres})
+			vals = append(vals, vres)
 			return true
 		})
 		if len(res.Indexes) != len(vals) {
-			return []byte(jstr), errNoChange
+			return edit{}, errNoChange
 		}
 		for i := 0; i < len(res.Indexes); i++ {
-			vals[i].index = res.Indexes[i]
+			vals[i].Index = res.Indexes[i]
+		}
+	}
+	if len(vals) == 0 {
+		return edit{}, errNoChange
+	}
+	sort.SliceStable(vals, func(i, j int) bool {
+		return vals[i].Index < vals[j].Index
+	})
+	e := edit{start: vals[0].Index, exists: true}
+	var buf []byte
+	for i, val := range vals {
+		if i > 0 {
+			if val.Index < e.end {
+				// overlapping value
+				continue
+			}
+			buf = append(buf, jstr[e.end:val.Index]...)
+		}
+		if stringify {
+			buf = appendStringify(buf, raw)
+		} else {
+			buf = append(buf, raw...)
+		}
+		e.end = val.Index + len(val.Raw)
+	}
+	e.mid = *(*string)(unsafe.Pointer(&buf))
+	return e, nil
+}
+
+// hasArrayComponent returns true if a component of the path refers to the
+// elements of an array with "#" or a query.
+func hasArrayComponent(comps []PathComponent) bool {
+	for _, c := range comps {
+		switch c.Kind {
+		case PathAll, PathQuery, PathQueryAll:
+			return true
+		}
+	}
+	return false
+}
+
+// matchComponents appends the values in res that are matched by the
+// components to vals. The Index of res, and of the values, is its position
+// in the original json.
+func matchComponents(res gjson.Result, comps []PathComponent,
+	vals []gjson.Result) []gjson.Result {
+	if len(comps) == 0 {
+		return append(vals, res)
+	}
+	c := comps[0]
+	switch c.Kind {
+	case PathAll:
+		if len(comps) > 1 && res.IsArray() {
+			res.ForEach(func(_, elem gjson.Result) bool {
+				vals = matchComponents(elem, comps[1:], vals)
+				return true
+			})
+		}
+	case PathQuery, PathQueryAll:
+		if !res.IsArray() {
+			break
+		}
+		query := c.Key
+		if c.Kind == PathQuery {
+			query += "#"
 		}
-		sort.SliceStable(vals, func(i, j int) bool {
-			return vals[i].index > vals[j].index
+		matches := gjson.Get(res.Raw, query).Indexes
+		var j int
+		res.ForEach(func(_, elem gjson.Result) bool {
+			for ; j < len(matches) && matches[j] < elem.Index-res.Index; j++ {
+			}
+			if j == len(matches) {
+				return false
+			}
+			if matches[j] == elem.Index-res.Index {
+				vals = matchComponents(elem, comps[1:], vals)
+				return c.Kind == PathQueryAll
+			}
+			return true
 		})
-		for _, val := range vals {
-			vres := val.res
-			index := val.index
-			njson := []byte(jstr[:index])
-			if stringify {
-				njson = appendStringify(njson, raw)
-			} else {
-				njson = append(njson, raw...)
+	case PathWildcard:
+		if elem := res.Get(c.Key); elem.Exists() && elem.Index > res.Index {
+			vals = matchComponents(elem, comps[1:], vals)
+		}
+	default:
+		if res.IsArray() {
+			if c.Kind == PathIndex {
+				var i int
+				res.ForEach(func(_, elem gjson.Result) bool {
+					if i == c.Index {
+						vals = matchComponents(elem, comps[1:], vals)
+						return false
+					}
+					i++
+					return true
+				})
 			}
-			njson = append(njson, jstr[index+len(vres.Raw):]...)
-			jstr = string(njson)
+			break
+		}
+		if !res.IsObject() {
+			break
 		}
+		res.ForEach(func(key, elem gjson.Result) bool {
+			if key.String() == c.Key {
+				vals = matchComponents(elem, comps[1:], vals)
+				return false
+			}
+			return true
+		})
 	}
-	return []byte(jstr), nil
+	return vals
 }
 
 // SetOptions sets a json value for the specified path with options.
@@ -743,6 +3586,37 @@ func SetOptions(json, path string, value interface{},
 	return string(res), err
 }
 
+// SetBytesOptionsMany sets the values for the paths, in order, as if
+// SetBytesOptions was called for each path and the value at the same index.
+// The paths and values must have the same length. An error identifying the
+// index of the path is returned when a value cannot be set, along with the
+// original json.
+//
+// With the ReplaceInPlace option the bytes of json are reused for the edits
+// that fit in its capacity, in which case json may have been changed when an
+// error is returned.
+func SetBytesOptionsMany(json []byte, paths []string, values []interface{},
+	opts *Options) ([]byte, error) {
+	if len(paths) != len(values) {
+		return json, &errorType{fmt.Sprintf(
+			"%d paths and %d values do not match", len(paths), len(values))}
+	}
+	if len(paths) == 0 {
+		return json, nil
+	}
+	buf := json
+	if opts == nil || !opts.ReplaceInPlace {
+		buf = append([]byte(nil), json...)
+	}
+	ed := &Editor{buf: buf, opts: opts}
+	for i, path := range paths {
+		if err := ed.Set(path, values[i]); err != nil {
+			return json, fmt.Errorf("path %d: %w", i, err)
+		}
+	}
+	return formatResult(ed.Bytes(), opts), nil
+}
+
 // SetBytesOptionsByGetResult - if you have already gotten the result, no need to get it in set again
 func SetBytesOptionsByGetResult(json []byte, getResult gjson.Result, value interface{},
 	opts *Options) ([]byte, error) {
@@ -752,60 +3626,12 @@ func SetBytesOptionsByGetResult(json []byte, getResult gjson.Result, value inter
 		inplace = opts.ReplaceInPlace
 	}
 	jstr := *(*string)(unsafe.Pointer(&json))
-	var res []byte
-	var err error
-	switch v := value.(type) {
-	default:
-		b, merr := jsongo.Marshal(value)
-		if merr != nil {
-			return nil, merr
-		}
-		raw := *(*string)(unsafe.Pointer(&b))
-		res, err = setByGetResult(jstr, raw, getResult, false, false, optimistic, inplace)
-	case dtype:
-		res, err = setByGetResult(jstr, "", getResult, false, true, optimistic, inplace)
-	case string:
-		res, err = setByGetResult(jstr, v, getResult, true, false, optimistic, inplace)
-	case []byte:
-		raw := *(*string)(unsafe.Pointer(&v))
-		res, err = setByGetResult(jstr, raw, getResult, true, false, optimistic, inplace)
-	case bool:
-		if v {
-			res, err = setByGetResult(jstr, "true", getResult, false, false, optimistic, inplace)
-		} else {
-			res, err = setByGetResult(jstr, "false", getResult, false, false, optimistic, inplace)
-		}
-	case int8:
-		res, err = setByGetResult(jstr, strconv.FormatInt(int64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case int16:
-		res, err = setByGetResult(jstr, strconv.FormatInt(int64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case int32:
-		res, err = setByGetResult(jstr, strconv.FormatInt(int64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case int64:
-		res, err = setByGetResult(jstr, strconv.FormatInt(int64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case uint8:
-		res, err = setByGetResult(jstr, strconv.FormatUint(uint64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case uint16:
-		res, err = setByGetResult(jstr, strconv.FormatUint(uint64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case uint32:
-		res, err = setByGetResult(jstr, strconv.FormatUint(uint64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case uint64:
-		res, err = setByGetResult(jstr, strconv.FormatUint(uint64(v), 10), getResult,
-			false, false, optimistic, inplace)
-	case float32:
-		res, err = setByGetResult(jstr, strconv.FormatFloat(float64(v), 'f', -1, 64), getResult,
-			false, false, optimistic, inplace)
-	case float64:
-		res, err = setByGetResult(jstr, strconv.FormatFloat(float64(v), 'f', -1, 64), getResult,
-			false, false, optimistic, inplace)
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return nil, err
 	}
+	res, err := setByGetResult(jstr, raw, getResult, stringify, del,
+		optimistic, inplace)
 	if err == errNoChange {
 		return json, nil
 	}
@@ -853,6 +3679,68 @@ func SetBytesOptionsManyByGetResult(json []byte, getResult []gjson.Result, value
 	return res, err
 }
 
+// DeleteBytesManyByGetResult deletes every value referenced by a result that
+// was returned by gjson for the json, such as for a "#.field" or "#(...)#"
+// path. Array elements are removed, and object fields are removed along with
+// their keys.
+func DeleteBytesManyByGetResult(json []byte, result gjson.Result,
+	opts *Options) ([]byte, error) {
+	var inplace bool
+	if opts != nil {
+		inplace = opts.ReplaceInPlace
+	}
+	jstr := *(*string)(unsafe.Pointer(&json))
+	if !result.Exists() {
+		return json, nil
+	}
+	type region struct{ start, end int }
+	var regions []region
+	if len(result.Indexes) > 0 {
+		var i int
+		result.ForEach(func(_, value gjson.Result) bool {
+			if i < len(result.Indexes) {
+				start, end := deleteRange(jstr, result.Indexes[i],
+					len(value.Raw))
+				regions = append(regions, region{start, end})
+			}
+			i++
+			return true
+		})
+		if i != len(result.Indexes) {
+			return json, &errorType{"result does not match the json"}
+		}
+	} else if result.Index > 0 {
+		start, end := deleteRange(jstr, result.Index, len(result.Raw))
+		regions = append(regions, region{start, end})
+	} else if result.IsArray() && len(result.Array()) == 0 {
+		// a query without matches
+		return json, nil
+	} else {
+		return json, &errorType{"result has no position in the json"}
+	}
+	sort.Slice(regions, func(i, j int) bool {
+		return regions[i].start < regions[j].start
+	})
+	var res []byte
+	if inplace {
+		// the json only shrinks, so the bytes are moved forward in place
+		res = json[:0]
+	} else {
+		res = make([]byte, 0, len(json))
+	}
+	var pos int
+	for _, r := range regions {
+		if r.start > pos {
+			res = append(res, jstr[pos:r.start]...)
+		}
+		if r.end > pos {
+			pos = r.end
+		}
+	}
+	res = append(res, jstr[pos:]...)
+	return formatResult(res, opts), nil
+}
+
 func getBytes(v interface{}) []byte {
 	return []byte(fmt.Sprintf("%v", v))
 }
@@ -862,70 +3750,227 @@ func getBytes(v interface{}) []byte {
 // SetOptions(string(data), path, value)
 func SetBytesOptions(json []byte, path string, value interface{},
 	opts *Options) ([]byte, error) {
-	var optimistic, inplace bool
-	if opts != nil {
-		optimistic = opts.Optimistic
-		inplace = opts.ReplaceInPlace
+	jstr := *(*string)(unsafe.Pointer(&json))
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return nil, &PathError{Path: path, Offset: -1, Err: err}
 	}
+	res, err := set(jstr, path, raw, stringify, del, opts)
+	if err == errNoChange {
+		res, err = json, nil
+	}
+	if err != nil {
+		return res, err
+	}
+	return formatResult(res, opts), nil
+}
+
+// SetBytesBuf sets a json value for the specified path with options and
+// appends the result to dst[:0], returning the extended slice. Reusing dst
+// across calls, such as with a sync.Pool, avoids allocating a new document
+// for each set. The result does not share bytes with json unless the
+// ReplaceInPlace option is set, and dst must not overlap json.
+func SetBytesBuf(dst, json []byte, path string, value interface{},
+	opts *Options) ([]byte, error) {
 	jstr := *(*string)(unsafe.Pointer(&json))
-	var res []byte
-	var err error
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return dst[:0], &PathError{Path: path, Offset: -1, Err: err}
+	}
+	e, err := setEdit(jstr, path, raw, stringify, del, opts)
+	switch {
+	case err == errNoChange:
+		dst = append(dst[:0], json...)
+	case err != nil:
+		return dst[:0], err
+	case opts != nil && opts.ReplaceInPlace && e.optimistic &&
+		e.size(jstr) <= len(jstr):
+		return formatResult(e.build(jstr, true), opts), nil
+	default:
+		dst = e.append(dst[:0], jstr)
+	}
+	if reformats(opts) {
+		dst = append(dst[:0], formatResult(dst, opts)...)
+	}
+	return dst, nil
+}
+
+// valueRaw returns the raw json for a value passed to one of the set
+// functions. The stringify return value is true when raw must be turned into
+// a json string, and del is true when the value is a deletion.
+func valueRaw(value interface{}, opts *Options) (raw string, stringify,
+	del bool, err error) {
 	switch v := value.(type) {
 	default:
 		b, merr := jsongo.Marshal(value)
 		if merr != nil {
-			return nil, merr
+			return "", false, false, merr
 		}
-		raw := *(*string)(unsafe.Pointer(&b))
-		res, err = set(jstr, path, raw, false, false, optimistic, inplace)
+		raw = *(*string)(unsafe.Pointer(&b))
 	case dtype:
-		res, err = set(jstr, path, "", false, true, optimistic, inplace)
+		del = true
+	case jsongo.Number:
+		if !validNumber(string(v)) {
+			return "", false, false,
+				&errorType{"invalid number literal '" + string(v) + "'"}
+		}
+		raw = string(v)
+	case *big.Int:
+		if v == nil {
+			raw = "null"
+		} else {
+			raw = v.String()
+		}
+	case *big.Float:
+		if v == nil {
+			raw = "null"
+		} else if v.IsInf() {
+			return "", false, false,
+				&errorType{"unsupported value " + v.String()}
+		} else {
+			raw = v.Text('g', -1)
+		}
+	case gjson.Result:
+		switch {
+		case !v.Exists():
+			raw = "null"
+		case v.Type == gjson.Number && !math.IsInf(v.Num, 0) &&
+			(opts == nil || !opts.PreserveNumberText):
+			raw, err = formatFloat(v.Num, opts)
+		default:
+			raw = v.Raw
+		}
 	case string:
-		res, err = set(jstr, path, v, true, false, optimistic, inplace)
+		if opts != nil && opts.ValueIsRaw {
+			raw = v
+		} else {
+			raw, stringify = stringRaw(v, opts)
+		}
 	case []byte:
-		raw := *(*string)(unsafe.Pointer(&v))
-		res, err = set(jstr, path, raw, true, false, optimistic, inplace)
+		if opts != nil && opts.ValueIsRaw {
+			raw = *(*string)(unsafe.Pointer(&v))
+		} else {
+			raw, stringify = stringRaw(*(*string)(unsafe.Pointer(&v)), opts)
+		}
 	case bool:
 		if v {
-			res, err = set(jstr, path, "true", false, false, optimistic, inplace)
+			raw = "true"
 		} else {
-			res, err = set(jstr, path, "false", false, false, optimistic, inplace)
+			raw = "false"
 		}
 	case int8:
-		res, err = set(jstr, path, strconv.FormatInt(int64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatInt(int64(v), 10)
 	case int16:
-		res, err = set(jstr, path, strconv.FormatInt(int64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatInt(int64(v), 10)
 	case int32:
-		res, err = set(jstr, path, strconv.FormatInt(int64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatInt(int64(v), 10)
 	case int64:
-		res, err = set(jstr, path, strconv.FormatInt(int64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatInt(int64(v), 10)
 	case uint8:
-		res, err = set(jstr, path, strconv.FormatUint(uint64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatUint(uint64(v), 10)
 	case uint16:
-		res, err = set(jstr, path, strconv.FormatUint(uint64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatUint(uint64(v), 10)
 	case uint32:
-		res, err = set(jstr, path, strconv.FormatUint(uint64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatUint(uint64(v), 10)
 	case uint64:
-		res, err = set(jstr, path, strconv.FormatUint(uint64(v), 10),
-			false, false, optimistic, inplace)
+		raw = strconv.FormatUint(uint64(v), 10)
 	case float32:
-		res, err = set(jstr, path, strconv.FormatFloat(float64(v), 'f', -1, 64),
-			false, false, optimistic, inplace)
+		raw, err = formatFloat(float64(v), opts)
 	case float64:
-		res, err = set(jstr, path, strconv.FormatFloat(float64(v), 'f', -1, 64),
-			false, false, optimistic, inplace)
+		raw, err = formatFloat(v, opts)
+	}
+	if err != nil {
+		return "", false, false, err
+	}
+	return raw, stringify, del, nil
+}
+
+// stringRaw returns the raw json for a string value. The string is returned
+// as is, to be stringified later, unless the options change how it's escaped.
+func stringRaw(s string, opts *Options) (raw string, stringify bool) {
+	if opts == nil || (!opts.DisableHTMLEscape && !opts.EscapeUnicode) ||
+		!mustMarshalString(s) {
+		return s, true
+	}
+	b := appendString(nil, s, !opts.DisableHTMLEscape, opts.EscapeUnicode)
+	return *(*string)(unsafe.Pointer(&b)), false
+}
+
+// validNumber returns true if the string is a json number, without any
+// surrounding whitespace.
+func validNumber(s string) bool {
+	if s == "" || (s[0] != '-' && (s[0] < '0' || s[0] > '9')) ||
+		s[len(s)-1] < '0' || s[len(s)-1] > '9' {
+		return false
+	}
+	return jsongo.Valid([]byte(s))
+}
+
+// formatFloat returns the json for a float value. The shortest
+// representation is used unless FloatFormat or FloatPrecision is set.
+func formatFloat(f float64, opts *Options) (string, error) {
+	format, prec := byte('f'), -1
+	if opts != nil {
+		switch opts.FloatFormat {
+		case 0:
+		case 'f', 'e', 'g':
+			format = opts.FloatFormat
+		default:
+			return "", &errorType{"invalid float format '" +
+				string(opts.FloatFormat) + "'"}
+		}
+		if opts.IntegerFloats && f == math.Trunc(f) && math.Abs(f) < 1e21 {
+			return strconv.FormatFloat(f, 'f', 0, 64), nil
+		}
+		if opts.FloatPrecision != 0 {
+			prec = opts.FloatPrecision
+		}
+	}
+	return strconv.FormatFloat(f, format, prec, 64), nil
+}
+
+// SetBytesInPlace sets a json value for the specified path, reusing the
+// bytes of json when the new value fits. This is the same as SetBytesOptions
+// with the Optimistic and ReplaceInPlace options.
+//
+// The json is owned by the function until it returns, after which only the
+// returned slice should be used. It must not be accessed concurrently, and
+// it must not be the backing memory of a string. Copy the json first when
+// it's shared with other goroutines.
+func SetBytesInPlace(json []byte, path string, value interface{}) ([]byte,
+	error) {
+	return SetBytesOptions(json, path, value,
+		&Options{Optimistic: true, ReplaceInPlace: true})
+}
+
+// SetBytesRange sets a json value for the specified path with options, and
+// returns the half-open range of the result that holds the newly written
+// bytes. The range is empty when the json is unchanged, such as when
+// deleting a path that does not exist, and covers the entire result when
+// an option such as Pretty reformats the document.
+func SetBytesRange(json []byte, path string, value interface{},
+	opts *Options) (result []byte, start, end int, err error) {
+	jstr := *(*string)(unsafe.Pointer(&json))
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return nil, 0, 0, &PathError{Path: path, Offset: -1, Err: err}
 	}
+	e, err := setEdit(jstr, path, raw, stringify, del, opts)
 	if err == errNoChange {
-		return json, nil
+		return json, 0, 0, nil
 	}
-	return res, err
+	if err != nil {
+		return json, 0, 0, err
+	}
+	start = e.start - e.lead
+	end = e.size(jstr) - (len(jstr) - e.end)
+	result = e.build(jstr, opts != nil && opts.ReplaceInPlace)
+	if res := formatResult(result, opts); len(res) != len(result) ||
+		(len(res) > 0 && &res[0] != &result[0]) {
+		// the document was reformatted
+		result, start, end = res, 0, len(res)
+	}
+	return result, start, end, nil
 }
 
 // SetRawBytesOptions sets a raw json value for the specified path with options.
@@ -935,14 +3980,146 @@ func SetRawBytesOptions(json []byte, path string, value []byte,
 	opts *Options) ([]byte, error) {
 	jstr := *(*string)(unsafe.Pointer(&json))
 	vstr := *(*string)(unsafe.Pointer(&value))
-	var optimistic, inplace bool
-	if opts != nil {
-		optimistic = opts.Optimistic
-		inplace = opts.ReplaceInPlace
-	}
-	res, err := set(jstr, path, vstr, false, false, optimistic, inplace)
+	res, err := set(jstr, path, vstr, false, false, opts)
 	if err == errNoChange {
-		return json, nil
+		res, err = json, nil
 	}
-	return res, err
+	if err != nil {
+		return res, err
+	}
+	return formatResult(res, opts), nil
+}
+
+// reformats returns true if the options change the formatting of the whole
+// resulting json, in which case formatResult rewrites it.
+func reformats(opts *Options) bool {
+	return opts != nil && (opts.Pretty || opts.SortKeys || opts.Compact ||
+		opts.NormalizeNumbers)
+}
+
+// formatResult applies the output options to the resulting json.
+func formatResult(json []byte, opts *Options) []byte {
+	if !reformats(opts) {
+		return json
+	}
+	if opts.NormalizeNumbers {
+		json = normalizeNumbers(json)
+	}
+	if opts.Pretty {
+		popts := *pretty.DefaultOptions
+		if opts.Indent != "" {
+			popts.Indent = opts.Indent
+		}
+		popts.SortKeys = opts.SortKeys
+		json = pretty.PrettyOptions(json, &popts)
+	} else if opts.SortKeys {
+		json = pretty.Ugly(pretty.PrettyOptions(json,
+			&pretty.Options{SortKeys: true}))
+	} else if opts.Compact {
+		json = pretty.Ugly(json)
+	}
+	return json
+}
+
+// normalizeNumbers returns the json with every number in its canonical
+// form. The json is returned as is when all of the numbers are canonical.
+func normalizeNumbers(json []byte) []byte {
+	var buf []byte
+	var mark int // the bytes of json before mark are in buf
+	for i := 0; i < len(json); i++ {
+		c := json[i]
+		if c == '"' {
+			for i++; i < len(json) && json[i] != '"'; i++ {
+				if json[i] == '\\' {
+					i++
+				}
+			}
+			continue
+		}
+		if c != '-' && c != '+' && c != '.' && (c < '0' || c > '9') {
+			continue
+		}
+		start := i
+		for ; i < len(json); i++ {
+			c := json[i]
+			if c != '-' && c != '+' && c != '.' && c != 'e' && c != 'E' &&
+				(c < '0' || c > '9') {
+				break
+			}
+		}
+		num := canonicalNumber(json[start:i])
+		if string(num) != string(json[start:i]) {
+			buf = append(buf, json[mark:start]...)
+			buf = append(buf, num...)
+			mark = i
+		}
+		i--
+	}
+	if buf == nil {
+		return json
+	}
+	return append(buf, json[mark:]...)
+}
+
+// canonicalNumber returns the number without a leading '+', leading zeros,
+// or an empty integer or fraction part, and with a lowercase exponent that
+// has no '+' or leading zeros, such as "1.50e3" for "+01.50E+03".
+func canonicalNumber(num []byte) []byte {
+	var out []byte
+	i := 0
+	if i < len(num) && (num[i] == '+' || num[i] == '-') {
+		if num[i] == '-' {
+			out = append(out, '-')
+		}
+		i++
+	}
+	start := i
+	for ; i < len(num) && num[i] >= '0' && num[i] <= '9'; i++ {
+	}
+	digits := num[start:i]
+	for len(digits) > 1 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 {
+		out = append(out, '0')
+	}
+	out = append(out, digits...)
+	var frac int
+	if i < len(num) && num[i] == '.' {
+		i++
+		start = i
+		for ; i < len(num) && num[i] >= '0' && num[i] <= '9'; i++ {
+		}
+		if frac = i - start; frac > 0 {
+			out = append(out, '.')
+			out = append(out, num[start:i]...)
+		}
+	}
+	if len(digits) == 0 && frac == 0 {
+		// not a number
+		return num
+	}
+	if i < len(num) && (num[i] == 'e' || num[i] == 'E') {
+		i++
+		out = append(out, 'e')
+		if i < len(num) && (num[i] == '+' || num[i] == '-') {
+			if num[i] == '-' {
+				out = append(out, '-')
+			}
+			i++
+		}
+		start = i
+		for ; i < len(num) && num[i] >= '0' && num[i] <= '9'; i++ {
+		}
+		digits = num[start:i]
+		for len(digits) > 1 && digits[0] == '0' {
+			digits = digits[1:]
+		}
+		out = append(out, digits...)
+	}
+	if i < len(num) {
+		// not a number that can be normalized
+		return num
+	}
+	return out
 }
@@ -1,11 +1,21 @@
 package sjson
 
 import (
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/tidwall/gjson"
 	"github.com/tidwall/pretty"
@@ -338,6 +348,39 @@ func TestIndexes(t *testing.T) {
 	}
 }
 
+func TestIndexesChained(t *testing.T) {
+	json := `{"cats":[` +
+		`{"type":"a","items":[{"on":true,"flag":0},{"on":false,"flag":0},` +
+		`{"on":true,"flag":0}]},` +
+		`{"type":"b","items":[{"on":true,"flag":0}]},` +
+		`{"type":"a","items":[{"on":false,"flag":0},{"on":true,"flag":0}]}]}`
+	tests := []struct {
+		path, expect string
+	}{
+		{`cats.#(type="a")#.items.#(on==true)#.flag`, `[[1,0,1],[0],[0,1]]`},
+		{`cats.#(type="a")#.items.#(on==true).flag`, `[[1,0,0],[0],[0,1]]`},
+		{`cats.#(type="a").items.#(on==true)#.flag`, `[[1,0,1],[0],[0,0]]`},
+		{`cats.#(type="a").items.#(on==false).flag`, `[[0,1,0],[0],[0,0]]`},
+		{`cats.#.items.#(on==true)#.flag`, `[[1,0,1],[1],[0,1]]`},
+		{`cats.#(type="b")#.items.#.flag`, `[[0,0,0],[1],[0,0]]`},
+		{`cats.#(type="c")#.items.#.flag`, `[[0,0,0],[0],[0,0]]`},
+		{`cats.#(type="a")#.items.#(on==true)#.missing`, `[[0,0,0],[0],[0,0]]`},
+	}
+	for i, tt := range tests {
+		res, err := Set(json, tt.path, 1)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if !gjson.Valid(res) {
+			t.Fatalf("%d: invalid json '%v'", i, res)
+		}
+		flags := gjson.Get(res, "cats.#.items.#.flag").Raw
+		if flags != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, flags)
+		}
+	}
+}
+
 func TestIssue61(t *testing.T) {
 	json := `{
 		"@context": {
@@ -445,3 +488,2936 @@ func TestSetBytesOptionsManyByGetResult(t *testing.T) {
 		})
 	}
 }
+
+func TestReplaceInPlaceEscapedString(t *testing.T) {
+	json := []byte(`{"name":"xxxxxxxxxxxxxxxx","age":37}`)
+	opts := &Options{Optimistic: true, ReplaceInPlace: true}
+	res, err := SetBytesOptions(json, "name", `"Tom"`, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":"\"Tom\"","age":37}`
+	if string(res) != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, string(res))
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		json, path string
+		values     []interface{}
+		expect     string
+	}{
+		{`{"items":[1,2]}`, "items", []interface{}{3}, `{"items":[1,2,3]}`},
+		{`{"items":[ ]}`, "items", []interface{}{"a", "b"}, `{"items":[ "a","b"]}`},
+		{`{"items":[1] }`, "items", nil, `{"items":[1] }`},
+		{`{}`, "items", []interface{}{true, nil}, `{"items":[true,null]}`},
+		{``, "a.items", []interface{}{1.5}, `{"a":{"items":[1.5]}}`},
+		{`{"a":{"-1":[]}}`, "a.:-1", []interface{}{1}, `{"a":{"-1":[1]}}`},
+		{`[[1],[2]]`, "1", []interface{}{3, 4}, `[[1],[2,3,4]]`},
+	}
+	for i, tt := range tests {
+		res, err := Append(tt.json, tt.path, tt.values...)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := AppendBytes([]byte(tt.json), tt.path, tt.values...)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(bres) != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, string(bres))
+		}
+	}
+	if _, err := Append(`{"items":{}}`, "items", 1); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := Append(`{"items":5}`, "items", 1); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, json := range []string{`{"a":[1,2`, `{"a":[`} {
+		res, err := Append(json, "a", 3)
+		if !errors.Is(err, ErrInvalidJSON) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrInvalidJSON, err)
+		}
+	}
+	json := []byte(`{"a":[]}`)
+	if res, err := AppendBytes(json, "a", func() {}); err == nil ||
+		string(res) != string(json) {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	tests := []struct {
+		json, path string
+		index      int
+		value      interface{}
+		expect     string
+	}{
+		{`{"a":[1,2,3]}`, "a", 0, 0, `{"a":[0,1,2,3]}`},
+		{`{"a":[1,2,3]}`, "a", 2, "x", `{"a":[1,2,"x",3]}`},
+		{`{"a":[1,2,3]}`, "a", 3, 4, `{"a":[1,2,3,4]}`},
+		{`{"a":[1,2,3]}`, "a", -1, 2.5, `{"a":[1,2,2.5,3]}`},
+		{`{"a":[1,2,3]}`, "a", -3, 0, `{"a":[0,1,2,3]}`},
+		{`{"a":[ 1, 2 ]}`, "a", 1, []int{9}, `{"a":[ 1, [9],2 ]}`},
+		{`{"a":[]}`, "a", 0, true, `{"a":[true]}`},
+		{`{}`, "a", 0, true, `{"a":[true]}`},
+		{`[[1,2]]`, "0", 1, nil, `[[1,null,2]]`},
+	}
+	for i, tt := range tests {
+		res, err := Insert(tt.json, tt.path, tt.index, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	for i, index := range []int{4, -4} {
+		if _, err := Insert(`{"a":[1,2,3]}`, "a", index, 0); err == nil {
+			t.Fatalf("%d: expected an error", i)
+		}
+	}
+	if _, err := Insert(`{"a":{}}`, "a", 0, 0); err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, json := range []string{`{"a":[`, `{"a":[1,2`} {
+		n := len(arrayElements(gjson.Get(json, "a")))
+		res, err := Insert(json, "a", n, 1)
+		if !errors.Is(err, ErrInvalidJSON) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrInvalidJSON, err)
+		}
+	}
+	json := []byte(`{"a":[1]}`)
+	if res, err := InsertBytes(json, "a", 0, func() {}); err == nil ||
+		string(res) != string(json) {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	json, err := DeleteWhere(example, "friends.#(age>45)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.Get(json, "friends.#.first").String() != `["Dale"]` {
+		t.Fatalf("mismatch: %v", gjson.Get(json, "friends").Raw)
+	}
+	json, err = DeleteWhere(example, `friends.#(last="Murphy")#`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gjson.Get(json, "friends.#.first").String() != `["Roger"]` {
+		t.Fatalf("mismatch: %v", gjson.Get(json, "friends").Raw)
+	}
+	json, err = DeleteWhere(example, "friends.#(age>100)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json != example {
+		t.Fatal("expected no change")
+	}
+	json, err = DeleteWhere(`[1,5,2,6,3]`, "#(>2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if json != `[1,2]` {
+		t.Fatalf("expected '%v', got '%v'", `[1,2]`, json)
+	}
+	if _, err := DeleteWhere(example, "friends.#(age>45).first"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := DeleteWhere(example, "name.#(age>45)"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDeleteFirstLastWhere(t *testing.T) {
+	json := `{"queue":[{"id":1,"s":"done"},{"id":2,"s":"new"},` +
+		`{"id":3,"s":"done"},{"id":4,"s":"done"}]}`
+	tests := []struct {
+		query  string
+		last   bool
+		expect string
+	}{
+		{`queue.#(s="done")`, false, "[2,3,4]"},
+		{`queue.#(s="done")#`, true, "[1,2,3]"},
+		{`queue.#(s="new")`, true, "[1,3,4]"},
+		{`queue.#(s="old")`, false, "[1,2,3,4]"},
+	}
+	for i, tt := range tests {
+		var res string
+		var err error
+		if tt.last {
+			res, err = DeleteLastWhere(json, tt.query)
+		} else {
+			res, err = DeleteFirstWhere(json, tt.query)
+		}
+		if err != nil || gjson.Get(res, "queue.#.id").Raw != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	res, err := DeleteLastWhere(`[1,5,2,6,3]`, "#(>2)")
+	if err != nil || res != `[1,5,2,6]` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	if _, err := DeleteFirstWhere(json, "id.#(s=1)"); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestPrettyOption(t *testing.T) {
+	json := `{"a":1,"b":[1,2]}`
+	res, err := SetOptions(json, "c.d", "x", &Options{Pretty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "{\n  \"a\": 1,\n  \"b\": [1, 2],\n  \"c\": {\n    \"d\": \"x\"\n  }\n}\n"
+	if res != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, res)
+	}
+	bres, err := SetRawBytesOptions([]byte(json), "a", []byte("2"),
+		&Options{Pretty: true, Indent: "\t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect = "{\n\t\"a\": 2,\n\t\"b\": [1, 2]\n}\n"
+	if string(bres) != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, string(bres))
+	}
+	res, err = SetRawOptions(json, "a", "1", &Options{Pretty: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "{\n  \"a\": 1,\n  \"b\": [1, 2]\n}\n" {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	res, err = SetOptions(json, "a", 2, &Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"a":2,"b":[1,2]}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		err    error
+		target error
+	}{
+		{func() error { _, err := Set(`{}`, "", 1); return err }(), ErrEmptyPath},
+		{func() error { _, err := Set(`[1]`, "a", 1); return err }(), ErrInvalidPath},
+		{func() error { _, err := Delete(`[1]`, "#.a"); return err }(), ErrInvalidPath},
+		{func() error { _, err := Set(`{"a":1`, "b", 1); return err }(), ErrInvalidJSON},
+		{func() error { _, err := Append(`{"a":1}`, "a", 1); return err }(), ErrNotAnArray},
+		{func() error { _, err := Insert(`{"a":[1]}`, "a", 2, 1); return err }(), ErrIndexOutOfRange},
+		{func() error { _, err := DeleteWhere(`{"a":1}`, "a.#(b>1)"); return err }(), ErrNotAnArray},
+		{func() error {
+			_, err := ApplyPatch(`[1]`, `[{"op":"add","path":"/3","value":1}]`)
+			return err
+		}(), ErrIndexOutOfRange},
+	}
+	for i, tt := range tests {
+		if !errors.Is(tt.err, tt.target) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.target, tt.err)
+		}
+	}
+	_, err := Set(`{"a":[1,2]}`, "a.b", 1)
+	var perr *PathError
+	if !errors.As(err, &perr) {
+		t.Fatalf("expected a PathError, got '%v'", err)
+	}
+	if perr.Path != "a.b" || perr.Offset != 5 || perr.Err != ErrInvalidPath {
+		t.Fatalf("unexpected %#v", perr)
+	}
+	if err.Error() != "invalid path at 'a.b'" {
+		t.Fatalf("unexpected '%v'", err)
+	}
+}
+
+func TestSetRawMany(t *testing.T) {
+	json := `{"name":{"first":"Tom"}}`
+	res, err := SetRawMany(json, []RawPathValue{
+		{"name.last", `"Anderson"`},
+		{"age", `37`},
+		{"children", `["Sara","Alex"]`},
+		{"children.-1", `{"name":"Jack"}`},
+		{"name.first", `"Janet"`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":{"first":"Janet","last":"Anderson"},"age":37,` +
+		`"children":["Sara","Alex",{"name":"Jack"}]}`
+	if res != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, res)
+	}
+	res, err = SetRawMany(json, []RawPathValue{
+		{"age", `37`},
+		{"name", `{"first":`},
+	})
+	if !errors.Is(err, ErrInvalidJSON) || err.Error() != "pair 1: invalid json at 'name'" {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if res != json {
+		t.Fatalf("expected '%v', got '%v'", json, res)
+	}
+	if _, err = SetRawMany(json, []RawPathValue{{"", `1`}}); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	if res, _ = SetRawMany(json, nil); res != json {
+		t.Fatalf("expected '%v', got '%v'", json, res)
+	}
+}
+
+type testMarshaler struct{ n int }
+
+func (m testMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`{"n":` + strconv.Itoa(m.n) + `}`), nil
+}
+
+type testTextMarshaler string
+
+func (m testTextMarshaler) MarshalText() ([]byte, error) {
+	return []byte("text:" + string(m)), nil
+}
+
+func TestSetMarshalers(t *testing.T) {
+	tm := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{tm, `{"v":"2020-01-02T03:04:05Z"}`},
+		{&tm, `{"v":"2020-01-02T03:04:05Z"}`},
+		{net.IPv4(10, 0, 0, 1), `{"v":"10.0.0.1"}`},
+		{testMarshaler{5}, `{"v":{"n":5}}`},
+		{testTextMarshaler("hi"), `{"v":"text:hi"}`},
+		{[]interface{}{tm, testMarshaler{1}}, `{"v":["2020-01-02T03:04:05Z",{"n":1}]}`},
+	}
+	for i, tt := range tests {
+		res, err := Set(`{}`, "v", tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+}
+
+func TestSetComplexValues(t *testing.T) {
+	type config struct {
+		Name    string            `json:"name"`
+		Port    int               `json:"port,omitempty"`
+		Tags    []string          `json:"tags"`
+		Labels  map[string]string `json:"labels"`
+		private int
+	}
+	res, err := Set(`{"id":1}`, "config", config{Name: "x",
+		Tags: []string{"a"}, Labels: map[string]string{"k": "v"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"id":1,"config":{"name":"x","tags":["a"],"labels":{"k":"v"}}}`
+	if res != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, res)
+	}
+	res, err = Set(`{}`, "m.n", map[string]interface{}{"a": []int{1}, "b": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"m":{"n":{"a":[1],"b":null}}}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	for _, value := range []interface{}{make(chan int), func() {}} {
+		_, err := Set(`{}`, "bad", value)
+		var perr *PathError
+		var uerr *json.UnsupportedTypeError
+		if !errors.As(err, &perr) || perr.Path != "bad" || !errors.As(err, &uerr) {
+			t.Fatalf("unexpected error '%v'", err)
+		}
+	}
+}
+
+func TestSetAll(t *testing.T) {
+	json := `{"friends":[{"name":"Dale","age":44},{"name":"Roger","age":68,` +
+		`"active":false},{"name":"Jane","age":47}]}`
+	tests := []struct {
+		path   string
+		value  interface{}
+		expect string
+	}{
+		{"friends.#.active", true, `{"friends":[{"name":"Dale","age":44,"active":true},` +
+			`{"name":"Roger","age":68,"active":true},{"name":"Jane","age":47,"active":true}]}`},
+		{"friends.#(age>45)#.flagged", 1, `{"friends":[{"name":"Dale","age":44},` +
+			`{"name":"Roger","age":68,"active":false,"flagged":1},{"name":"Jane","age":47,"flagged":1}]}`},
+		{"friends.#(age>45).flagged", 1, `{"friends":[{"name":"Dale","age":44},` +
+			`{"name":"Roger","age":68,"active":false,"flagged":1},{"name":"Jane","age":47}]}`},
+		{"friends.#(age>90)#.flagged", 1, json},
+		{"friends.#", 0, `{"friends":[0,0,0]}`},
+		{"friends.#(name%\"J*\")#", "x", `{"friends":[{"name":"Dale","age":44},` +
+			`{"name":"Roger","age":68,"active":false},"x"]}`},
+		{"enemies.#.active", true, json},
+		{"friends.0.name", "Tom", `{"friends":[{"name":"Tom","age":44},` +
+			`{"name":"Roger","age":68,"active":false},{"name":"Jane","age":47}]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetAll(json, tt.path, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	res, err := SetAll(`[{"a":[{},{}]},{"a":[{}]}]`, "#.a.#.b", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `[{"a":[{"b":1},{"b":1}]},{"a":[{"b":1}]}]` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	if _, err := SetAll(`{"a":{}}`, "a.#.b", 1); !errors.Is(err, ErrNotAnArray) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAnArray, err)
+	}
+}
+
+func TestMatchPaths(t *testing.T) {
+	json := `{"friends":[{"last":"Murphy","age":44},{"last":"Craig","age":68},` +
+		`{"age":47,"tags":[{},{}]}]}`
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"friends.#.last", "friends.0.last,friends.1.last,friends.2.last"},
+		{"friends.#(age>45)#.last", "friends.1.last,friends.2.last"},
+		{"friends.#(age>45).last", "friends.1.last"},
+		{"friends.#.tags.#.x", "friends.2.tags.0.x,friends.2.tags.1.x"},
+		{"friends.#(age>90)#.last", ""},
+		{"enemies.#.last", ""},
+		{"friends.0.last", "friends.0.last"},
+	}
+	for i, tt := range tests {
+		paths, err := MatchPaths(json, tt.path)
+		if err != nil || strings.Join(paths, ",") != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, paths,
+				err)
+		}
+		// setting each path is the same as SetAll
+		res := json
+		for j := len(paths) - 1; j >= 0; j-- {
+			res, _ = Set(res, paths[j], 1)
+		}
+		if expect, _ := SetAll(json, tt.path, 1); res != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, res)
+		}
+	}
+	if _, err := MatchPaths(`{"a":{}}`, "a.#.b"); !errors.Is(err,
+		ErrNotAnArray) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAnArray, err)
+	}
+	if _, err := MatchPaths(json, ""); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestPruneEmptyParents(t *testing.T) {
+	opts := &Options{PruneEmptyParents: true}
+	tests := []struct {
+		json, path, expect string
+	}{
+		{`{"a":{"b":{"c":1}},"d":2}`, "a.b.c", `{"d":2}`},
+		{`{"a":{"b":{"c":1},"e":3},"d":2}`, "a.b.c", `{"a":{"e":3},"d":2}`},
+		{`{"a":{"b":{"c":1}}}`, "a.b.c", `{}`},
+		{`{"a":{"b":{"c":1,"f":2}}}`, "a.b.c", `{"a":{"b":{"f":2}}}`},
+		{`{"a":[{"b":1}],"d":2}`, "a.0.b", `{"d":2}`},
+		{`{"a":[{"b":1}],"d":2}`, "a.-1.b", `{"d":2}`},
+		{`{"a":[1],"d":2}`, "a.-1", `{"d":2}`},
+		{`{"a":[1,2],"d":2}`, "a.0", `{"a":[2],"d":2}`},
+		{`{"a":{"b":{}}}`, "a.b.c", `{"a":{"b":{}}}`},
+		{`{"a":{"x.y":1},"d":2}`, `a.x\.y`, `{"d":2}`},
+		{`{ "a" : { "b" : 1 } , "d" : 2 }`, "a.b", `{ "d" : 2 }`},
+	}
+	for i, tt := range tests {
+		res, err := DeleteOptions(tt.json, tt.path, opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := DeleteBytesOptions([]byte(tt.json), tt.path, opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(bres) != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, string(bres))
+		}
+	}
+	res, _ := DeleteOptions(`{"a":{"b":1}}`, "a.b", nil)
+	if res != `{"a":{}}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	json := `{"a":1,"b":null,"c":{"d":[1,2]}}`
+	tests := []struct {
+		path   string
+		set    bool
+		expect string
+	}{
+		{"a", false, json},
+		{"b", false, json},
+		{"c.d", false, json},
+		{"c.d.1", false, json},
+		{"c.d.#", false, json},
+		{"e", true, `{"a":1,"b":null,"c":{"d":[1,2]},"e":"x"}`},
+		{"c.e", true, `{"a":1,"b":null,"c":{"d":[1,2],"e":"x"}}`},
+		{"c.d.2", true, `{"a":1,"b":null,"c":{"d":[1,2,"x"]}}`},
+		{"c.d.-1", true, `{"a":1,"b":null,"c":{"d":[1,2,"x"]}}`},
+	}
+	for i, tt := range tests {
+		res, set, err := SetIfAbsent(json, tt.path, "x")
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if set != tt.set || res != tt.expect {
+			t.Fatalf("%d: expected '%v' %v, got '%v' %v", i, tt.expect, tt.set,
+				res, set)
+		}
+	}
+	if _, _, err := SetIfAbsent(json, "", 1); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	tests := []struct {
+		json, path string
+		delta      float64
+		expect     string
+	}{
+		{`{"n":5}`, "n", 1, `{"n":6}`},
+		{`{"n":5}`, "n", -7, `{"n":-2}`},
+		{`{"n":5}`, "n", 0.5, `{"n":5.5}`},
+		{`{"n":5.5}`, "n", 1, `{"n":6.5}`},
+		{`{"n":1e2}`, "n", 1, `{"n":101}`},
+		{`{"n":9007199254740993}`, "n", 1, `{"n":9007199254740994}`},
+		{`{"n":9223372036854775807}`, "n", 1, `{"n":9223372036854776000}`},
+		{`{}`, "a.b", 3, `{"a":{"b":3}}`},
+		{`{}`, "a", 2.25, `{"a":2.25}`},
+		{`{"a":[1,2]}`, "a.1", 10, `{"a":[1,12]}`},
+	}
+	for i, tt := range tests {
+		res, err := Add(tt.json, tt.path, tt.delta)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	for _, json := range []string{`{"n":"5"}`, `{"n":null}`, `{"n":{}}`} {
+		res, err := Add(json, "n", 1)
+		if !errors.Is(err, ErrNotANumber) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrNotANumber, err)
+		}
+	}
+	nonFinite := []struct {
+		json  string
+		delta float64
+	}{
+		{`{"a":1}`, math.NaN()},
+		{`{}`, math.Inf(1)},
+		{`{"a":1}`, math.Inf(-1)},
+		{`{"a":1e308}`, 1e308},
+		{`{"a":1e400}`, 1},
+	}
+	for i, tt := range nonFinite {
+		res, err := Add(tt.json, "a", tt.delta)
+		var perr *PathError
+		if !errors.As(err, &perr) || perr.Path != "a" || res != tt.json {
+			t.Fatalf("%d: expected an error, got '%v' (%v)", i, res, err)
+		}
+	}
+}
+
+func TestSetObject(t *testing.T) {
+	fields := map[string]interface{}{"b": 2, "a.x": "one", "0": true}
+	tests := []struct {
+		json, parentPath, expect string
+	}{
+		{`{"obj":{"c":3,"b":0}}`, "obj",
+			`{"obj":{"c":3,"b":2,"0":true,"a.x":"one"}}`},
+		{`{}`, "p.obj", `{"p":{"obj":{"0":true,"a.x":"one","b":2}}}`},
+		{`{"obj":5}`, "obj", `{"obj":{"0":true,"a.x":"one","b":2}}`},
+		{`{"c":3}`, "", `{"c":3,"0":true,"a.x":"one","b":2}`},
+		{`{"x":[{"y":{}}]}`, "x.0.y", `{"x":[{"y":{"0":true,"a.x":"one","b":2}}]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetObject(tt.json, tt.parentPath, fields)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	res, err := SetObjectOrdered(`{}`, "obj", []string{"b", "0"}, fields)
+	if err != nil || res != `{"obj":{"b":2,"0":true}}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	if _, err := SetObjectOrdered(`{}`, "obj", []string{"z"}, fields); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := SetObject(`{"obj":[]}`, "obj", fields); !errors.Is(err,
+		ErrInvalidPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidPath, err)
+	}
+}
+
+func TestSetTemplate(t *testing.T) {
+	tests := []struct {
+		json, template string
+		vars           map[string]string
+		expect         string
+	}{
+		{`{}`, "users.{id}.name", map[string]string{"id": "a.b"},
+			`{"users":{"a.b":{"name":1}}}`},
+		{`{"users":{"42":{}}}`, "users.{id}.name", map[string]string{"id": "42"},
+			`{"users":{"42":{"name":1}}}`},
+		{`{"users":[{},{}]}`, "users.{i}.name", map[string]string{"i": "1"},
+			`{"users":[{},{"name":1}]}`},
+		{`{}`, "{a}.{b}", map[string]string{"a": "x*", "b": `y#\`},
+			`{"x*":{"y#\\":1}}`},
+		{`{}`, "{a}", map[string]string{"a": ":k"}, `{":k":1}`},
+		{`{}`, `a\{b}`, nil, `{"a{b}":1}`},
+		{`{}`, "{a}{b}", map[string]string{"a": "x", "b": "y"}, `{"xy":1}`},
+	}
+	for i, tt := range tests {
+		res, err := SetTemplate(tt.json, tt.template, tt.vars, 1)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	for _, template := range []string{"users.{id}.name", "users.{id"} {
+		res, err := SetTemplate(`{}`, template, map[string]string{"x": "1"}, 1)
+		var perr *PathError
+		if !errors.As(err, &perr) || perr.Path != template || res != `{}` {
+			t.Fatalf("unexpected '%v' (%v)", res, err)
+		}
+	}
+}
+
+func TestRoundSet(t *testing.T) {
+	tests := []struct {
+		value, step float64
+		expect      string
+	}{
+		{0.149, 0.05, `{"n":0.15}`},
+		{0.125, 0.05, `{"n":0.15}`},
+		{1.02, 0.05, `{"n":1}`},
+		{12.3456, 0.01, `{"n":12.35}`},
+		{-0.01, 0.05, `{"n":0}`},
+		{-2.26, 0.25, `{"n":-2.25}`},
+		{1234, 100, `{"n":1200}`},
+		{0.7, 0.1, `{"n":0.7}`},
+		{3, 1, `{"n":3}`},
+	}
+	for i, tt := range tests {
+		res, err := RoundSet(`{"n":1}`, "n", tt.value, tt.step)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	for _, step := range []float64{0, -1, math.NaN(), math.Inf(1)} {
+		res, err := RoundSet(`{}`, "n", 1, step)
+		if err == nil || res != `{}` {
+			t.Fatalf("unexpected '%v' (%v)", res, err)
+		}
+	}
+	if _, err := RoundSet(`{}`, "n", math.NaN(), 1); err == nil {
+		t.Fatal("expected an error")
+	}
+	res, err := RoundSet(`{}`, "n", 1e308, 1e-308)
+	if err == nil || res != `{}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+}
+
+func TestFloatFormat(t *testing.T) {
+	tests := []struct {
+		opts   *Options
+		value  interface{}
+		expect string
+	}{
+		{nil, 0.30000000000000004, `{"v":0.30000000000000004}`},
+		{&Options{}, 1e21, `{"v":1000000000000000000000}`},
+		{&Options{FloatPrecision: 2}, 0.30000000000000004, `{"v":0.30}`},
+		{&Options{FloatPrecision: 2}, float32(19.999), `{"v":20.00}`},
+		{&Options{FloatFormat: 'f'}, 2.5, `{"v":2.5}`},
+		{&Options{FloatFormat: 'e'}, 1.5, `{"v":1.5e+00}`},
+		{&Options{FloatFormat: 'f', FloatPrecision: 1}, 2.25, `{"v":2.2}`},
+		{&Options{FloatFormat: 'e', FloatPrecision: -1}, 1e21, `{"v":1e+21}`},
+		{&Options{FloatFormat: 'g', FloatPrecision: 3}, 3.14159, `{"v":3.14}`},
+		{&Options{FloatPrecision: 2}, 5, `{"v":5}`},
+		{nil, float64(3), `{"v":3}`},
+		{&Options{FloatPrecision: 2}, float64(3), `{"v":3.00}`},
+		{&Options{FloatPrecision: 2, IntegerFloats: true}, float64(3),
+			`{"v":3}`},
+		{&Options{FloatFormat: 'e', IntegerFloats: true}, float32(-40),
+			`{"v":-40}`},
+		{&Options{FloatPrecision: 2, IntegerFloats: true}, 2.5, `{"v":2.50}`},
+		{&Options{IntegerFloats: true}, 1e20, `{"v":100000000000000000000}`},
+		{&Options{FloatFormat: 'g', FloatPrecision: -1, IntegerFloats: true},
+			1e21, `{"v":1e+21}`},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(`{}`, "v", tt.value, tt.opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	for _, format := range []byte{'x', 'b', 'E', 'G'} {
+		if _, err := SetOptions(`{}`, "v", 2.0,
+			&Options{FloatFormat: format}); err == nil {
+			t.Fatalf("%c: expected an error", format)
+		}
+	}
+}
+
+func TestSetNumbers(t *testing.T) {
+	bi, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	bf, _ := new(big.Float).SetPrec(200).SetString("3.14159265358979323846264338327950288")
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{json.Number("12345678901234567890"), `{"v":12345678901234567890}`},
+		{json.Number("-1.5e300"), `{"v":-1.5e300}`},
+		{bi, `{"v":123456789012345678901234567890}`},
+		{new(big.Int).Neg(bi), `{"v":-123456789012345678901234567890}`},
+		{bf, `{"v":3.14159265358979323846264338327950288}`},
+		{big.NewFloat(1e100), `{"v":1e+100}`},
+		{(*big.Int)(nil), `{"v":null}`},
+	}
+	for i, tt := range tests {
+		res, err := Set(`{}`, "v", tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	for _, value := range []interface{}{json.Number(""), json.Number("12a"),
+		json.Number(`"1"`), json.Number("01"), new(big.Float).SetInf(false)} {
+		if res, err := Set(`{}`, "v", value); err == nil {
+			t.Fatalf("expected an error for '%v', got '%v'", value, res)
+		}
+	}
+}
+
+func TestDisableHTMLEscape(t *testing.T) {
+	opts := &Options{DisableHTMLEscape: true}
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{"<b>", `{"v":"<b>"}`},
+		{"<b>\"&\"</b>\n", `{"v":"<b>\"&\"</b>\n"}`},
+		{[]byte("a&b\t\x01"), `{"v":"a&b\t\u0001"}`},
+		{"\u2028 😇 \xff", `{"v":"\u2028 😇 \ufffd"}`},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(`{}`, "v", tt.value, opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	res, _ := Set(`{}`, "v", "<b>\"&\"</b>")
+	if res != `{"v":"\u003cb\u003e\"\u0026\"\u003c/b\u003e"}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+}
+
+func TestAppendString(t *testing.T) {
+	seed := time.Now().UnixNano()
+	rng := rand.New(rand.NewSource(seed))
+	chars := []string{"a", "<", "&", "\"", "\\", "\n", "\x00", "\x1f", "\x7f",
+		"é", "😇", " ", "\xff", "\xe2\x82"}
+	for i := 0; i < 10000; i++ {
+		var s string
+		for j := rng.Intn(10); j >= 0; j-- {
+			s += chars[rng.Intn(len(chars))]
+		}
+		for _, flags := range [][2]bool{{false, false}, {true, false},
+			{false, true}, {true, true}} {
+			html, ascii := flags[0], flags[1]
+			b := appendString(nil, s, html, ascii)
+			var v string
+			if err := json.Unmarshal(b, &v); err != nil {
+				t.Fatalf("seed %d: %q: %v", seed, b, err)
+			}
+			expect, _ := json.Marshal(s)
+			var ev string
+			json.Unmarshal(expect, &ev)
+			if v != ev {
+				t.Fatalf("seed %d: expected %q, got %q", seed, ev, v)
+			}
+			if html && strings.ContainsAny(string(b), "<>&") {
+				t.Fatalf("seed %d: %q", seed, b)
+			}
+			if ascii && strings.IndexFunc(string(b), func(r rune) bool {
+				return r >= utf8.RuneSelf
+			}) != -1 {
+				t.Fatalf("seed %d: %q", seed, b)
+			}
+		}
+	}
+}
+
+func TestEscapeUnicode(t *testing.T) {
+	opts := &Options{EscapeUnicode: true}
+	tests := []struct {
+		value  interface{}
+		expect string
+	}{
+		{"😇", `{"v":"\ud83d\ude07"}`},
+		{"caf\u00e9 <b>", `{"v":"caf\u00e9 \u003cb\u003e"}`},
+		{"\u2028\n\xff", `{"v":"\u2028\n\ufffd"}`},
+		{"plain", `{"v":"plain"}`},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(`{}`, "v", tt.value, opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		if i == 0 && gjson.Get(res, "v").String() != "😇" {
+			t.Fatalf("%d: emoji did not round trip", i)
+		}
+	}
+	res, _ := SetOptions(`{}`, "😇", "😇", nil)
+	if res != `{"😇":"😇"}` || gjson.Get(res, "😇").String() != "😇" {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	res, _ = SetOptions(`{}`, "v", "é<", &Options{EscapeUnicode: true,
+		DisableHTMLEscape: true})
+	if res != `{"v":"\u00e9<"}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	// raw json is left alone
+	res, _ = SetRawOptions(`{}`, "v", `"😇"`, opts)
+	if res != `{"v":"😇"}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	json := `{"a":{"x":1.50,"b":[1, 2e3]},"c":"d"}`
+	tests := []struct {
+		from, to, expect string
+	}{
+		{"a", "e", `{"a":{"x":1.50,"b":[1, 2e3]},"c":"d","e":{"x":1.50,"b":[1, 2e3]}}`},
+		{"a.b", "c", `{"a":{"x":1.50,"b":[1, 2e3]},"c":[1, 2e3]}`},
+		{"c", "a.b.-1", `{"a":{"x":1.50,"b":[1, 2e3,"d"]},"c":"d"}`},
+		{"a.x", "f.g", `{"a":{"x":1.50,"b":[1, 2e3]},"c":"d","f":{"g":1.50}}`},
+		{"a", "a.y", `{"a":{"x":1.50,"b":[1, 2e3],"y":{"x":1.50,"b":[1, 2e3]}},"c":"d"}`},
+	}
+	for i, tt := range tests {
+		res, err := Copy(json, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	res, err := Copy(json, "missing", "e")
+	if !errors.Is(err, ErrPathNotFound) || res != json {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+}
+
+func TestSetBytesInPlace(t *testing.T) {
+	json := []byte(`{"a":"hello","b":2}`)
+	res, err := SetBytesInPlace(json, "a", "howdy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != `{"a":"howdy","b":2}` || &res[0] != &json[0] {
+		t.Fatalf("expected an in-place edit, got '%s'", res)
+	}
+	res, err = SetBytesInPlace(res, "c", "a new member")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != `{"a":"howdy","b":2,"c":"a new member"}` {
+		t.Fatalf("unexpected '%s'", res)
+	}
+	// each goroutine owns its buffer, which is reused for many edits
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buf := []byte(`{"n":0000000000,"id":` + strconv.Itoa(i) + `}`)
+			for j := 0; j < 1000; j++ {
+				var err error
+				buf, err = SetBytesInPlace(buf, "n", j)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			expect := `{"n":999,"id":` + strconv.Itoa(i) + `}`
+			if string(buf) != expect {
+				t.Errorf("expected '%v', got '%s'", expect, buf)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSetMap(t *testing.T) {
+	m := map[string]interface{}{
+		"name.last":  "Anderson",
+		"name":       map[string]string{"first": "Tom"},
+		"age":        37,
+		"children":   []string{"Sara"},
+		"children.1": "Alex",
+	}
+	expect := `{"id":1,"age":37,"children":["Sara","Alex"],` +
+		`"name":{"first":"Tom","last":"Anderson"}}`
+	for i := 0; i < 10; i++ {
+		res, err := SetMap(`{"id":1}`, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expect {
+			t.Fatalf("expected '%v', got '%v'", expect, res)
+		}
+	}
+	res, err := SetOrdered(`{}`, []string{"b", "a"}, map[string]interface{}{
+		"a": 1, "b": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != `{"b":2,"a":1}` {
+		t.Fatalf("unexpected '%v'", res)
+	}
+	_, err = SetMap(`{}`, map[string]interface{}{"a": 1, "": 2})
+	if !errors.Is(err, ErrEmptyPath) || err.Error() != "key '': path cannot be empty" {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	res, err = SetOrdered(`{}`, []string{"a", "b"}, map[string]interface{}{"a": 1})
+	if err == nil || res != `{}` {
+		t.Fatalf("expected an error, got '%v'", res)
+	}
+}
+
+func TestDeleteIndexBounds(t *testing.T) {
+	json := `{"arr":[1,2,3],"empty":[],"obj":{"-2":1}}`
+	tests := []struct {
+		path, expect string
+	}{
+		{"arr.0", `{"arr":[2,3],"empty":[],"obj":{"-2":1}}`},
+		{"arr.2", `{"arr":[1,2],"empty":[],"obj":{"-2":1}}`},
+		{"arr.-1", `{"arr":[1,2],"empty":[],"obj":{"-2":1}}`},
+		{"arr.-2", `{"arr":[1,3],"empty":[],"obj":{"-2":1}}`},
+		{"arr.-3", `{"arr":[2,3],"empty":[],"obj":{"-2":1}}`},
+		{"arr.3", json},
+		{"arr.-4", json},
+		{"arr.-5", json},
+		{"arr.-99999999999999999999", json},
+		{"empty.0", json},
+		{"empty.-1", json},
+		{"obj.-2", `{"arr":[1,2,3],"empty":[],"obj":{}}`},
+	}
+	for i, tt := range tests {
+		res, err := Delete(json, tt.path)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		bres, err := DeleteBytes([]byte(json), tt.path)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(bres) != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, string(bres))
+		}
+	}
+}
+
+func TestSetBytesRange(t *testing.T) {
+	json := `{"a":"hello","b":[1,2],"c":{}}`
+	tests := []struct {
+		path  string
+		value interface{}
+		opts  *Options
+		mid   string
+	}{
+		{"a", "howdy", nil, `"howdy"`},
+		{"a", "say \"hi\"", nil, `"say \"hi\""`},
+		{"b.1", 3, nil, `3`},
+		{"b.-1", 3, nil, `,3]`},
+		{"c.d", true, nil, `"d":true}`},
+		{"e", 1, nil, `,"e":1}`},
+		{"b.0", nil, nil, `null`},
+		{"a", "hi", &Options{Optimistic: true, ReplaceInPlace: true}, `"hi"`},
+		{"b", dtype{}, nil, ``},
+	}
+	for i, tt := range tests {
+		expect, _ := SetOptions(json, tt.path, tt.value, tt.opts)
+		res, start, end, err := SetBytesRange([]byte(json), tt.path,
+			tt.value, tt.opts)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if string(res) != expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, expect, string(res))
+		}
+		if string(res[start:end]) != tt.mid {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.mid,
+				string(res[start:end]))
+		}
+	}
+	res, start, end, _ := SetBytesRange([]byte(json), "x", 1,
+		&Options{Pretty: true})
+	if start != 0 || end != len(res) {
+		t.Fatalf("expected the entire range, got %d-%d", start, end)
+	}
+	res, start, end, _ = SetBytesRange([]byte(json), "x", dtype{}, nil)
+	if string(res) != json || start != end {
+		t.Fatalf("expected an empty range, got %d-%d", start, end)
+	}
+}
+
+func TestSetMany(t *testing.T) {
+	json := `{"name":{"first":"Tom"}}`
+	pairs := []PathValue{
+		{"name.last", "Anderson"},
+		{"age", 37},
+		{"children", []string{"Sara", "Alex"}},
+		{"children.-1", "Jack"},
+		{"name.first", dtype{}},
+	}
+	res, err := SetMany(json, pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"name":{"last":"Anderson"},"age":37,"children":["Sara","Alex","Jack"]}`
+	if res != expect {
+		t.Fatalf("expected '%v', got '%v'", expect, res)
+	}
+	res, err = SetMany(json, []PathValue{{"a", 1}, {"", 2}})
+	if !errors.Is(err, ErrEmptyPath) || err.Error() != "pair 1: path cannot be empty" ||
+		res != json {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err = SetManyContext(ctx, json, pairs)
+	if err != nil || res != expect {
+		t.Fatalf("expected '%v', got '%v' %v", expect, res, err)
+	}
+	cancel()
+	res, err = SetManyContext(ctx, json, pairs)
+	if !errors.Is(err, context.Canceled) || res != json ||
+		err.Error() != "applied 0 of 5 pairs: context canceled" {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	// the context is done after the check that precedes the pair, while
+	// the pair is scanning a large document
+	big := `{"a":"` + strings.Repeat("x", 8<<20) + `","b":1}`
+	ctx = &scanContext{Context: ctx}
+	res, err = SetManyContext(ctx, big, []PathValue{{"b", 2}})
+	if !errors.Is(err, context.Canceled) || res != big ||
+		err.Error() != "applied 0 of 1 pairs: context canceled" {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+	res, err = SetManyContext(context.Background(), big, []PathValue{{"b", 2}})
+	if err != nil || res != big[:len(big)-2]+"2}" {
+		t.Fatalf("unexpected error '%v'", err)
+	}
+}
+
+// scanContext is a done context that reports no error on its first check.
+type scanContext struct {
+	context.Context
+	checks int
+}
+
+func (ctx *scanContext) Err() error {
+	ctx.checks++
+	if ctx.checks == 1 {
+		return nil
+	}
+	return ctx.Context.Err()
+}
+
+func TestWouldChange(t *testing.T) {
+	doc := `{"a":1,"b":"hello","c":{"x":1,"y":2},"d":[1,2]}`
+	tests := []struct {
+		path   string
+		value  interface{}
+		change bool
+	}{
+		{"a", 1, false},
+		{"a", 1.0, false},
+		{"a", 2, true},
+		{"b", "hello", false},
+		{"b", "hellp", true},
+		{"b", []byte("hello"), false},
+		{"c", map[string]int{"x": 1, "y": 2}, false},
+		{"c.x", 1, false},
+		{"d.1", 2, false},
+		{"d.-1", 2, true},
+		{"e", nil, true},
+		{"e", dtype{}, false},
+		{"a", dtype{}, true},
+		{"d.#", 1, false},
+	}
+	for i, tt := range tests {
+		change, err := WouldChange(doc, tt.path, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if change != tt.change {
+			t.Fatalf("%d: expected %v, got %v", i, tt.change, change)
+		}
+		res, _ := Set(doc, tt.path, tt.value)
+		if (res != doc) != change {
+			t.Fatalf("%d: result does not agree", i)
+		}
+	}
+	for _, tt := range []struct {
+		json, raw string
+	}{{`{"a":1}`, `1.0`}, {`{"a":{"x":1,"y":2}}`, `{"y":2,"x":1}`}} {
+		value := json.RawMessage(tt.raw)
+		if change, _ := WouldChange(tt.json, "a", value); !change {
+			t.Fatalf("expected a change for '%s'", tt.raw)
+		}
+	}
+	for i, tt := range []struct {
+		json, value string
+		change      bool
+	}{
+		{`{"a":[12]}`, "12", true},
+		{`{"a":[true]}`, "true", true},
+		{`{"a":[1234]}`, "23", true},
+		{`{"a":["12"]}`, "12", false},
+	} {
+		change, err := WouldChange(tt.json, "a.0", tt.value)
+		if err != nil || change != tt.change {
+			t.Fatalf("%d: expected %v, got %v (%v)", i, tt.change, change, err)
+		}
+		if res, _ := Set(tt.json, "a.0", tt.value); (res != tt.json) != change {
+			t.Fatalf("%d: result does not agree", i)
+		}
+	}
+}
+
+func TestSetNull(t *testing.T) {
+	tests := []struct {
+		json, path, expect string
+	}{
+		{`{"a":1}`, "a", `{"a":null}`},
+		{`{"a":1}`, "b", `{"a":1,"b":null}`},
+		{`{}`, "a.b.c", `{"a":{"b":{"c":null}}}`},
+		{`{"a":[1]}`, "a.-1", `{"a":[1,null]}`},
+		{`{"a":[1]}`, "a.2", `{"a":[1,null,null]}`},
+		{``, "a.0", `{"a":[null]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetNull(tt.json, tt.path)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		for _, value := range []interface{}{nil, (*int)(nil),
+			map[string]int(nil), []int(nil), json.RawMessage(nil)} {
+			res, err := Set(tt.json, tt.path, value)
+			if err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if res != tt.expect {
+				t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+			}
+		}
+	}
+}
+
+func TestSetIfEquals(t *testing.T) {
+	doc := `{"version":3,"name":"Tom","tags":["a","b"],"meta":{"x":1,"y":null}}`
+	tests := []struct {
+		path     string
+		expected interface{}
+		swapped  bool
+	}{
+		{"version", 3, true},
+		{"version", 3.0, true},
+		{"version", json.Number("3e0"), true},
+		{"version", 4, false},
+		{"version", "3", false},
+		{"name", "Tom", true},
+		{"name", "tom", false},
+		{"tags", []string{"a", "b"}, true},
+		{"tags", []string{"b", "a"}, false},
+		{"meta", json.RawMessage(`{ "y" : null , "x" : 1.0 }`), true},
+		{"meta.y", nil, true},
+		{"meta.z", nil, false},
+	}
+	for i, tt := range tests {
+		res, swapped, err := SetIfEquals(doc, tt.path, tt.expected, 5)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		expect := doc
+		if tt.swapped {
+			expect, _ = Set(doc, tt.path, 5)
+		}
+		if swapped != tt.swapped || res != expect {
+			t.Fatalf("%d: expected '%v' %v, got '%v' %v", i, expect,
+				tt.swapped, res, swapped)
+		}
+	}
+	if _, _, err := SetIfEquals(doc, "version", make(chan int), 5); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDeleteBytesManyByGetResult(t *testing.T) {
+	doc := `{"friends":[
+		{"first":"Dale","last":"Murphy","age":44},
+		{"first":"Roger","last":"Craig","age":68},
+		{"first":"Jane","age":47,"last":"Murphy"}
+	],"list":[1, 2, 3, 4]}`
+	tests := []struct {
+		path, expect string
+	}{
+		{"friends.#.last", `{"friends":[
+		{"first":"Dale","age":44},
+		{"first":"Roger","age":68},
+		{"first":"Jane","age":47}
+	],"list":[1, 2, 3, 4]}`},
+		{"friends.#.first", `{"friends":[
+		{"last":"Murphy","age":44},
+		{"last":"Craig","age":68},
+		{"age":47,"last":"Murphy"}
+	],"list":[1, 2, 3, 4]}`},
+		// same as deleting each element
+		{"friends.#(last==\"Murphy\")#", ""},
+		{"friends.#(age>40)#", ""},
+		{"list.#(>2)#", `{"friends":[
+		{"first":"Dale","last":"Murphy","age":44},
+		{"first":"Roger","last":"Craig","age":68},
+		{"first":"Jane","age":47,"last":"Murphy"}
+	],"list":[1, 2]}`},
+		{"list.1", `{"friends":[
+		{"first":"Dale","last":"Murphy","age":44},
+		{"first":"Roger","last":"Craig","age":68},
+		{"first":"Jane","age":47,"last":"Murphy"}
+	],"list":[1, 3, 4]}`},
+		{"friends", `{"list":[1, 2, 3, 4]}`},
+		{"missing", doc},
+		{"friends.#.missing", doc},
+	}
+	for i, tt := range tests {
+		if tt.expect == "" {
+			tt.expect, _ = DeleteWhere(doc, tt.path)
+		}
+		for _, opts := range []*Options{nil, {ReplaceInPlace: true}} {
+			json := []byte(doc)
+			res, err := DeleteBytesManyByGetResult(json, gjson.GetBytes(json, tt.path), opts)
+			if err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if string(res) != tt.expect {
+				t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, string(res))
+			}
+			if !gjson.ValidBytes(res) {
+				t.Fatalf("%d: invalid json", i)
+			}
+		}
+	}
+	_, err := DeleteBytesManyByGetResult([]byte(doc), gjson.Get(doc, "list|@reverse"), nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestDisableAutoCreate(t *testing.T) {
+	opts := &Options{DisableAutoCreate: true}
+	doc := `{"a":{"b":[1,{"c":2}]},"d":5}`
+	tests := []struct {
+		path   string
+		expect string
+	}{
+		{"a.b.1.c", `{"a":{"b":[1,{"c":"x"}]},"d":5}`},
+		{"a.b.1.e", `{"a":{"b":[1,{"c":2,"e":"x"}]},"d":5}`},
+		{"a.b.-1", `{"a":{"b":[1,{"c":2},"x"]},"d":5}`},
+		{"a.f", `{"a":{"b":[1,{"c":2}],"f":"x"},"d":5}`},
+		{"g", `{"a":{"b":[1,{"c":2}]},"d":5,"g":"x"}`},
+		{"a.x.y", ""},
+		{"a.b.2.c", ""},
+		{"a.b.-1.c", ""},
+		{"d.e", ""},
+		{"a.b.0.c", ""},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(doc, tt.path, "x", opts)
+		if tt.expect == "" {
+			if !errors.Is(err, ErrPathNotFound) || res != doc {
+				t.Fatalf("%d: expected '%v', got '%v'", i, ErrPathNotFound, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	if res, err := SetOptions(``, "a", 1, opts); err != nil || res != `{"a":1}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	if _, err := SetOptions(`1`, "a", 1, opts); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+}
+
+func TestSetTyped(t *testing.T) {
+	tests := []struct {
+		value, expect string
+	}{
+		{"true", `{"v":true}`},
+		{"false", `{"v":false}`},
+		{"null", `{"v":null}`},
+		{"42", `{"v":42}`},
+		{"-42", `{"v":-42}`},
+		{"0", `{"v":0}`},
+		{"3.14", `{"v":3.14}`},
+		{"1e-7", `{"v":1e-7}`},
+		{"123456789012345678901234567890", `{"v":123456789012345678901234567890}`},
+		{"007", `{"v":"007"}`},
+		{"-01", `{"v":"-01"}`},
+		{"+1", `{"v":"+1"}`},
+		{".5", `{"v":".5"}`},
+		{"5.", `{"v":"5."}`},
+		{"NaN", `{"v":"NaN"}`},
+		{"True", `{"v":"True"}`},
+		{" 42", `{"v":" 42"}`},
+		{"42 ", `{"v":"42 "}`},
+		{"42\n", `{"v":"42\n"}`},
+		{"-1.5\t", `{"v":"-1.5\t"}`},
+		{"", `{"v":""}`},
+		{"hello", `{"v":"hello"}`},
+	}
+	for i, tt := range tests {
+		res, err := SetTyped(`{}`, "v", tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	res, err := SetTypedOptions(`{}`, "v", "42", &TypedOptions{ForceString: true})
+	if err != nil || res != `{"v":"42"}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = SetTypedOptions(`{"a": 1}`, "v", "42",
+		&TypedOptions{Options: Options{Compact: true}})
+	if err != nil || res != `{"a":1,"v":42}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestSetBytesBuf(t *testing.T) {
+	pool := sync.Pool{New: func() interface{} { return make([]byte, 0, 256) }}
+	json := []byte(`{"a":{"b":1},"c":[1,2]}`)
+	orig := string(json)
+	tests := []struct {
+		path  string
+		value interface{}
+		opts  *Options
+	}{
+		{"a.b", 2, nil},
+		{"a.d", "hello", nil},
+		{"c.-1", 3, nil},
+		{"a", dtype{}, nil},
+		{"missing", dtype{}, nil},
+		{"a.b", 2, &Options{Pretty: true}},
+		{"a.b", 2, &Options{Optimistic: true}},
+	}
+	for i, tt := range tests {
+		dst := pool.Get().([]byte)
+		dst = append(dst, "garbage"...)
+		expect, experr := SetBytesOptions([]byte(orig), tt.path, tt.value, tt.opts)
+		res, err := SetBytesBuf(dst, json, tt.path, tt.value, tt.opts)
+		if err != nil || experr != nil {
+			t.Fatalf("%d: %v %v", i, err, experr)
+		}
+		if string(res) != string(expect) {
+			t.Fatalf("%d: expected '%s', got '%s'", i, expect, res)
+		}
+		if &res[:1][0] != &dst[:1][0] {
+			t.Fatalf("%d: expected dst to be reused", i)
+		}
+		if string(json) != orig {
+			t.Fatalf("%d: input was modified", i)
+		}
+		pool.Put(res)
+	}
+	if _, err := SetBytesBuf(nil, json, "", 1, nil); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+	// in place
+	json = []byte(`{"a":12345}`)
+	res, err := SetBytesBuf(nil, json, "a", 1,
+		&Options{Optimistic: true, ReplaceInPlace: true})
+	if err != nil || string(res) != `{"a":1}` || &res[0] != &json[0] {
+		t.Fatalf("unexpected '%s' %v", res, err)
+	}
+}
+
+func TestMaxArrayGrowth(t *testing.T) {
+	opts := &Options{MaxArrayGrowth: 1000}
+	tests := []struct {
+		json, path string
+		ok         bool
+	}{
+		{`{"arr":[1,2]}`, "arr.1002", true},
+		{`{"arr":[1,2]}`, "arr.1003", false},
+		{`{"arr":[1,2]}`, "arr.1000000", false},
+		{`{"arr":[1,2]}`, "arr.1", true},
+		{`{"arr":[1,2]}`, "arr.-1", true},
+		{`{}`, "arr.1000", true},
+		{`{}`, "arr.1001", false},
+		{`{}`, "arr.500.500", true},
+		{`{}`, "arr.500.501", false},
+		{``, "1001", false},
+		{`{"arr":[1,2]}`, "arr.0.1000000", false}, // replaces a number
+		{`{"arr":[[1,2]]}`, "arr.0.1000000", false},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(tt.json, tt.path, true, opts)
+		if tt.ok {
+			expect, _ := Set(tt.json, tt.path, true)
+			if err != nil || res != expect {
+				t.Fatalf("%d: expected '%v', got '%v' %v", i, expect, res, err)
+			}
+		} else if !errors.Is(err, ErrArrayGrowth) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, ErrArrayGrowth, err)
+		}
+	}
+}
+
+func TestNoNullPadding(t *testing.T) {
+	opts := &Options{NoNullPadding: true}
+	tests := []struct {
+		json, path string
+		ok         bool
+	}{
+		{`{"arr":[1,2]}`, "arr.2", true},
+		{`{"arr":[1,2]}`, "arr.3", false},
+		{`{"arr":[1,2]}`, "arr.0", true},
+		{`{"arr":[1,2]}`, "arr.-1", true},
+		{`{}`, "arr.0", true},
+		{`{}`, "arr.1", false},
+		{`{}`, "arr.0.0.x", true},
+		{`{}`, "arr.0.1", false},
+		{``, "0", true},
+		{``, "1", false},
+		{`{"arr":[1,2]}`, "arr.0.1", false}, // replaces a number
+		{`{"arr":[[1,2]]}`, "arr.0.2", true},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(tt.json, tt.path, true, opts)
+		if tt.ok {
+			expect, _ := Set(tt.json, tt.path, true)
+			if err != nil || res != expect {
+				t.Fatalf("%d: expected '%v', got '%v' %v", i, expect, res, err)
+			}
+		} else if !errors.Is(err, ErrIndexOutOfRange) || res != tt.json {
+			t.Fatalf("%d: expected '%v', got '%v'", i, ErrIndexOutOfRange,
+				err)
+		}
+	}
+}
+
+func TestPostEditHook(t *testing.T) {
+	errSchema := errors.New("age must be a number")
+	var calls int
+	opts := &Options{PostEditHook: func(json []byte) error {
+		calls++
+		if age := gjson.GetBytes(json, "age"); age.Exists() &&
+			age.Type != gjson.Number {
+			return errSchema
+		}
+		return nil
+	}}
+	res, err := SetOptions(`{"age":1}`, "age", 2, opts)
+	if err != nil || res != `{"age":2}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	res, err = SetOptions(`{"age":1}`, "age", "two", opts)
+	if err != errSchema || res != `{"age":1}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	json := []byte(`{"age":1111}`)
+	bopts := *opts
+	bopts.Optimistic, bopts.ReplaceInPlace = true, true
+	if _, err := SetBytesOptions(json, "age", "x", &bopts); err != errSchema ||
+		string(json) != `{"age":1111}` {
+		t.Fatalf("unexpected '%s' (%v)", json, err)
+	}
+	if _, err := DeleteOptions(`{"age":1}`, "name", opts); err != nil ||
+		calls != 3 {
+		t.Fatalf("unexpected %d calls (%v)", calls, err)
+	}
+	var pretty string
+	opts = &Options{Pretty: true, PostEditHook: func(json []byte) error {
+		pretty = string(json)
+		return nil
+	}}
+	res, err = SetOptions(`{}`, "a", 1, opts)
+	if err != nil || res != pretty {
+		t.Fatalf("expected '%v', got '%v' (%v)", res, pretty, err)
+	}
+}
+
+func TestNormalizeNumbers(t *testing.T) {
+	opts := &Options{NormalizeNumbers: true}
+	json := `{"a":471.,"b":+5,"c":1E+05,"d":-007.50,"e":.5,"f":"471. +5",` +
+		`"g":[1.e-03,0,-0.0,true,null],"h":12345678901234567890}`
+	expect := `{"a":471,"b":5,"c":1e5,"d":-7.50,"e":0.5,"f":"471. +5",` +
+		`"g":[1e-3,0,-0.0,true,null],"h":12345678901234567890,"x":1}`
+	res, err := SetOptions(json, "x", 1, opts)
+	if err != nil || res != expect {
+		t.Fatalf("expected '%v', got '%v' (%v)", expect, res, err)
+	}
+	res, err = SetOptions(`{"a":1,"s":"\"1."}`, "a", 2, opts)
+	if err != nil || res != `{"a":2,"s":"\"1."}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	res, err = SetOptions(json, "x", 1, nil)
+	if err != nil || res != json[:len(json)-1]+`,"x":1}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	b := []byte(`{"a":471.,"b":1}`)
+	bres, err := SetBytesOptions(b, "b", 2, &Options{NormalizeNumbers: true,
+		Optimistic: true, ReplaceInPlace: true})
+	if err != nil || string(bres) != `{"a":471,"b":2}` {
+		t.Fatalf("unexpected '%s' (%v)", bres, err)
+	}
+	for _, path := range []string{"b", "c"} {
+		bres, err = SetBytesBuf(make([]byte, 0, 64), []byte(`{"a":1.50E+03}`),
+			path, dtype{}, opts)
+		if err != nil || string(bres) != `{"a":1.50e3}` {
+			t.Fatalf("unexpected '%s' (%v)", bres, err)
+		}
+	}
+	bres, err = SetBytesBuf(nil, []byte(`{"a":1.50E+03}`), "b", 2, opts)
+	if err != nil || string(bres) != `{"a":1.50e3,"b":2}` {
+		t.Fatalf("unexpected '%s' (%v)", bres, err)
+	}
+}
+
+func TestStrictPathEscaping(t *testing.T) {
+	opts := &Options{StrictPathEscaping: true}
+	for _, path := range []string{`a\.b`, `\:1`, `a\\b`, `\#`, `a\*b\?`,
+		`a.\-1`} {
+		expect, err := Set(`{}`, path, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := SetOptions(`{}`, path, 1, opts)
+		if err != nil || res != expect {
+			t.Fatalf("%s: expected '%v', got '%v' (%v)", path, expect, res, err)
+		}
+	}
+	tests := []struct {
+		path  string
+		index string
+	}{
+		{`a\`, "index 1"},
+		{`a.b\nc`, "index 3"},
+		{`a\.b.\1`, "index 5"},
+		{`a.\\\ b`, "index 4"},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(`{}`, tt.path, 1, opts)
+		if !errors.Is(err, ErrInvalidPath) || res != `{}` ||
+			!strings.Contains(err.Error(), tt.index) {
+			t.Fatalf("%d: unexpected '%v' (%v)", i, res, err)
+		}
+		if _, err := DeleteOptions(`{}`, tt.path, opts); !errors.Is(err,
+			ErrInvalidPath) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, ErrInvalidPath, err)
+		}
+		if _, err := Set(`{}`, tt.path, 1); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	opts := &Options{MaxDepth: 3}
+	tests := []struct {
+		json, path string
+		expect     string // empty for ErrMaxDepth
+	}{
+		{`{}`, "a.b.c", `{"a":{"b":{"c":1}}}`},
+		{`{}`, "a.b.c.d", ``},
+		{`{}`, "a.0.b.-1", ``},
+		{`{}`, `a.b\.c.d`, `{"a":{"b.c":{"d":1}}}`},
+		{`{"a":{"b":{"c":{"d":0}}}}`, "a.b.c.d", `{"a":{"b":{"c":{"d":1}}}}`},
+		{`{"a":{"b":{"c":{}}}}`, "a.b.c.d", ``},
+		{`{"a":[{"b":[0]}]}`, "a.#(b.0==0).b.0", `{"a":[{"b":[1]}]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(tt.json, tt.path, 1, opts)
+		if tt.expect == "" {
+			if !errors.Is(err, ErrMaxDepth) || res != tt.json {
+				t.Fatalf("%d: unexpected '%v' (%v)", i, res, err)
+			}
+		} else if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	res, err := DeleteOptions(`{"a":{"b":{"c":{"d":0}}}}`, "a.b.c.d", opts)
+	if err != nil || res != `{"a":{"b":{"c":{}}}}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+}
+
+func TestPreserveTrailingNewline(t *testing.T) {
+	opts := &Options{PreserveTrailingNewline: true}
+	tests := []struct {
+		json, path string
+		value      interface{}
+		expect     string
+	}{
+		{"{\"a\":1}\n", "b", 2, "{\"a\":1,\"b\":2}\n"},
+		{"{\"a\":1}\r\n", "b", 2, "{\"a\":1,\"b\":2}\r\n"},
+		{"{\"a\":1}\n", "a", 2, "{\"a\":2}\n"},
+		{"{\"a\":1,\"b\":2}\n", "b", dtype{}, "{\"a\":1}\n"},
+		{"[1]\n", "-1", 2, "[1,2]\n"},
+		{"5\n", "a", 1, "{\"a\":1}\n"},
+		{"{\"a\":1}", "b", 2, "{\"a\":1,\"b\":2}"},
+		{"{\"a\":1}\n\n", "b", 2, "{\"a\":1,\"b\":2}\n"},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(tt.json, tt.path, tt.value, opts)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected %q, got %q (%v)", i, tt.expect, res, err)
+		}
+	}
+	res, err := Set("{\"a\":1}\n", "b", 2)
+	if err != nil || res != "{\"a\":1,\"b\":2}" {
+		t.Fatalf("unexpected %q (%v)", res, err)
+	}
+}
+
+func TestValueIsRaw(t *testing.T) {
+	opts := &Options{ValueIsRaw: true}
+	tests := []struct {
+		value  interface{}
+		opts   *Options
+		expect string
+	}{
+		{`{"b":[1,2]}`, opts, `{"a":{"b":[1,2]}}`},
+		{[]byte(`true`), opts, `{"a":true}`},
+		{`{"b":1}`, nil, `{"a":"{\"b\":1}"}`},
+		{[]byte(`true`), nil, `{"a":"true"}`},
+		{5, opts, `{"a":5}`},
+		{gjson.Parse(`"x"`), opts, `{"a":"x"}`},
+	}
+	for i, tt := range tests {
+		res, err := SetOptions(`{"a":0}`, "a", tt.value, tt.opts)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	res, err := SetOptions(`{"a":0}`, "a", `{"b":`,
+		&Options{ValueIsRaw: true, ValidateResult: true})
+	if !errors.Is(err, ErrInvalidJSON) || res != `{"a":0}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+}
+
+func TestStrictContainers(t *testing.T) {
+	tests := []struct {
+		json, path string
+		expect     string // the default result, or empty for an error
+		strict     bool   // strict mode returns ErrNotAContainer
+	}{
+		{`{"a":5}`, "a.b", `{"a":{"b":1}}`, true},
+		{`{"a":"s"}`, "a.b.c", `{"a":{"b":{"c":1}}}`, true},
+		{`{"a":5}`, "a.0", `{"a":[1]}`, true},
+		{`{"a":null}`, "a.b", `{"a":{"b":1}}`, true},
+		{`{"a":[5]}`, "a.0.b", `{"a":[{"b":1}]}`, true},
+		{`5`, "a", `{"a":1}`, true},
+		{`{"a":5}`, "a", `{"a":1}`, false},
+		{`{"a":{}}`, "a.b.c", `{"a":{"b":{"c":1}}}`, false},
+		{`{"a":[]}`, "a.1", `{"a":[null,1]}`, false},
+		{``, "a.b", `{"a":{"b":1}}`, false},
+	}
+	for i, tt := range tests {
+		res, err := Set(tt.json, tt.path, 1)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+		res, err = SetOptions(tt.json, tt.path, 1,
+			&Options{StrictContainers: true})
+		if tt.strict {
+			var perr *PathError
+			if !errors.Is(err, ErrNotAContainer) || !errors.As(err, &perr) ||
+				perr.Path != tt.path || res != tt.json {
+				t.Fatalf("%d: unexpected '%v' (%v)", i, res, err)
+			}
+		} else if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+}
+
+func TestRenameKey(t *testing.T) {
+	tests := []struct {
+		json, path, name, expect string
+	}{
+		{`{"a":1,"b":2,"c":3}`, "b", "x", `{"a":1,"x":2,"c":3}`},
+		{` { "a" : 1 , "b" : [ 1,2 ] }`, "b", "c", ` { "a" : 1 , "c" : [ 1,2 ] }`},
+		{`{"a":{"b":{"c":1},"d":2}}`, "a.b", "fav.movie",
+			`{"a":{"fav.movie":{"c":1},"d":2}}`},
+		{`{"a.b":1}`, `a\.b`, `q"uote`, `{"q\"uote":1}`},
+		{`{"1":{"2":true}}`, ":1.:2", "3", `{"1":{"3":true}}`},
+		{`{"a":[{"b":1}]}`, "a.0.b", "c", `{"a":[{"c":1}]}`},
+		{`{"a":1,"a":2}`, "a", "b", `{"b":1,"a":2}`},
+		{`{"a":1}`, "a", "a", `{"a":1}`},
+	}
+	for i, tt := range tests {
+		res, err := RenameKey(tt.json, tt.path, tt.name)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	errs := []struct {
+		json, path, name string
+		err              error
+	}{
+		{`{"a":1}`, "", "b", ErrEmptyPath},
+		{`{"a":1}`, "b", "c", ErrPathNotFound},
+		{`{"a":1}`, "x.b", "c", ErrPathNotFound},
+		{`{"a":1,"b":2}`, "a", "b", ErrKeyExists},
+		{`{"a":[1,2]}`, "a.0", "b", ErrInvalidPath},
+		{`{"a":[{"b":1}]}`, "a.#.b", "c", ErrInvalidPath},
+	}
+	for i, tt := range errs {
+		res, err := RenameKey(tt.json, tt.path, tt.name)
+		if !errors.Is(err, tt.err) || res != tt.json {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.err, err)
+		}
+	}
+}
+
+func TestDuplicateKey(t *testing.T) {
+	json := `{"id":1,"x":{"id":5,"id":6},"id":2}`
+	tests := []struct {
+		path   string
+		dup    int
+		value  interface{}
+		expect string
+	}{
+		{"id", 0, 9, `{"id":9,"x":{"id":5,"id":6},"id":2}`},
+		{"id", 1, 9, `{"id":1,"x":{"id":5,"id":6},"id":9}`},
+		{"x.id", 1, 9, `{"id":1,"x":{"id":5,"id":9},"id":2}`},
+		{"id", 0, dtype{}, `{"x":{"id":5,"id":6},"id":2}`},
+		{"id", 1, dtype{}, `{"id":1,"x":{"id":5,"id":6}}`},
+		{"x.id", 1, dtype{}, `{"id":1,"x":{"id":5},"id":2}`},
+		{"id", 2, dtype{}, json},
+	}
+	for i, tt := range tests {
+		for _, optimistic := range []bool{false, true} {
+			res, err := SetOptions(json, tt.path, tt.value,
+				&Options{DuplicateKey: tt.dup, Optimistic: optimistic})
+			if err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if res != tt.expect {
+				t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+			}
+		}
+	}
+	_, err := SetOptions(json, "id", 9, &Options{DuplicateKey: 2})
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+}
+
+func TestTrimArray(t *testing.T) {
+	json := `{"log":[1,2,3,4,5],"x":1}`
+	tests := []struct {
+		fn     func() (string, error)
+		expect string
+	}{
+		{func() (string, error) { return TrimArray(json, "log", 2) },
+			`{"log":[4,5],"x":1}`},
+		{func() (string, error) { return TrimArray(json, "log", 0) },
+			`{"log":[],"x":1}`},
+		{func() (string, error) { return TrimArray(json, "log", 5) }, json},
+		{func() (string, error) { return TrimArray(json, "log", 100) }, json},
+		{func() (string, error) { return TrimArray(json, "missing", 1) }, json},
+		{func() (string, error) { return TrimArray(`{"1":[1,2]}`, ":1", 1) },
+			`{"1":[2]}`},
+		{func() (string, error) { return TrimArrayFirst(json, "log", 2) },
+			`{"log":[1,2],"x":1}`},
+		{func() (string, error) { return TrimArrayFirst(json, "log", 0) },
+			`{"log":[],"x":1}`},
+		{func() (string, error) { return SliceArray(json, "log", 1, 3) },
+			`{"log":[2,3],"x":1}`},
+		{func() (string, error) { return SliceArray(json, "log", -5, 1) },
+			`{"log":[1],"x":1}`},
+		{func() (string, error) { return SliceArray(json, "log", 7, 9) },
+			`{"log":[],"x":1}`},
+		{func() (string, error) {
+			return TrimArray(`{"a":[ {"b":1} , [2] ,  3 ]}`, "a", 2)
+		}, `{"a":[ [2] ,  3 ]}`},
+		{func() (string, error) {
+			return TrimArrayFirst("{\"a\":[\n  1,\n  2,\n  3\n]}", "a", 2)
+		}, "{\"a\":[\n  1,\n  2\n]}"},
+	}
+	for i, tt := range tests {
+		res, err := tt.fn()
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	if _, err := TrimArray(json, "x", 1); !errors.Is(err, ErrNotAnArray) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAnArray, err)
+	}
+	if _, err := TrimArray(json, "log", -1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected '%v', got '%v'", ErrIndexOutOfRange, err)
+	}
+	if _, err := SliceArray(json, "log", 3, 1); !errors.Is(err, ErrIndexOutOfRange) {
+		t.Fatalf("expected '%v', got '%v'", ErrIndexOutOfRange, err)
+	}
+}
+
+func TestSetWhere(t *testing.T) {
+	json := `{"friends":[{"first":"Dale","last":"Murphy"},` +
+		`{"first":"Roger","last":"Craig"},{"first":"Jane","last":"Murphy"}]}`
+	tests := []struct {
+		query, field string
+		value        interface{}
+		all          bool
+		expect       string
+	}{
+		{`friends.#(last="Murphy")`, "age", 44, false,
+			`{"friends":[{"first":"Dale","last":"Murphy","age":44},` +
+				`{"first":"Roger","last":"Craig"},{"first":"Jane","last":"Murphy"}]}`},
+		{`friends.#(last="Murphy")#`, "age", 44, true,
+			`{"friends":[{"first":"Dale","last":"Murphy","age":44},` +
+				`{"first":"Roger","last":"Craig"},` +
+				`{"first":"Jane","last":"Murphy","age":44}]}`},
+		{`friends.#(last="Murphy")`, "last", "Smith", true,
+			`{"friends":[{"first":"Dale","last":"Smith"},` +
+				`{"first":"Roger","last":"Craig"},{"first":"Jane","last":"Smith"}]}`},
+		{`friends.#(first="Roger")`, "name.nick", "Rog", false,
+			`{"friends":[{"first":"Dale","last":"Murphy"},` +
+				`{"first":"Roger","last":"Craig","name":{"nick":"Rog"}},` +
+				`{"first":"Jane","last":"Murphy"}]}`},
+		{`friends.#(first="Roger")`, "", 1, false,
+			`{"friends":[{"first":"Dale","last":"Murphy"},1,` +
+				`{"first":"Jane","last":"Murphy"}]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetWhere(json, tt.query, tt.field, tt.value, tt.all)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	res, err := SetWhere(`[{"a":1},{"a":2},{"a":3}]`, `#(a>1)`, "b", true, true)
+	if err != nil || res != `[{"a":1},{"a":2,"b":true},{"a":3,"b":true}]` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = SetWhere(json, `friends.#(last="Nobody")`, "age", 1, false)
+	if !errors.Is(err, ErrPathNotFound) || res != json {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+	_, err = SetWhere(json, `friends.0`, "age", 1, false)
+	if !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidPath, err)
+	}
+}
+
+func TestValidateResult(t *testing.T) {
+	opts := &Options{ValidateResult: true}
+	res, err := SetRawOptions(`{"a":1}`, "b", `{"c":`, opts)
+	if !errors.Is(err, ErrInvalidJSON) {
+		t.Fatalf("expected '%v', got '%v'", ErrInvalidJSON, err)
+	}
+	if res != `{"a":1}` {
+		t.Fatalf("expected '%v', got '%v'", `{"a":1}`, res)
+	}
+	res, err = SetRawOptions(`{"a":1}`, "b", `{"c":2}`, opts)
+	if err != nil || res != `{"a":1,"b":{"c":2}}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	// without the option the broken document is returned
+	res, err = SetRaw(`{"a":1}`, "b", `{"c":`)
+	if err != nil || res != `{"a":1,"b":{"c":}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	json := []byte(`{"a":12345}`)
+	opts = &Options{ValidateResult: true, Optimistic: true,
+		ReplaceInPlace: true}
+	_, err = SetRawBytesOptions(json, "a", []byte(`[1`), opts)
+	if !errors.Is(err, ErrInvalidJSON) || string(json) != `{"a":12345}` {
+		t.Fatalf("unexpected '%s' %v", json, err)
+	}
+	bres, err := DeleteBytesOptions(json, "a", opts)
+	if err != nil || string(bres) != `{}` {
+		t.Fatalf("unexpected '%s' %v", bres, err)
+	}
+}
+
+func TestSetRawValidate(t *testing.T) {
+	json := `{"a":1}`
+	for _, raw := range []string{`{"c":`, ``, `[1,]`, `tru`, `1 2`, `"a`} {
+		res, err := SetRawValidate(json, "b", raw)
+		if !errors.Is(err, ErrInvalidJSON) || res != json {
+			t.Fatalf("%s: expected '%v', got '%v'", raw, ErrInvalidJSON, err)
+		}
+		if err.Error() != "invalid json at 'b'" {
+			t.Fatalf("%s: unexpected '%v'", raw, err)
+		}
+		bres, err := SetRawBytesValidate([]byte(json), "b", []byte(raw))
+		if !errors.Is(err, ErrInvalidJSON) || string(bres) != json {
+			t.Fatalf("%s: expected '%v', got '%v'", raw, ErrInvalidJSON, err)
+		}
+	}
+	res, err := SetRawValidate(json, "b", ` {"c":[1,2]} `)
+	if err != nil || res != `{"a":1,"b": {"c":[1,2]} }` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	bres, err := SetRawBytesValidate([]byte(json), "a", []byte(`null`))
+	if err != nil || string(bres) != `{"a":null}` {
+		t.Fatalf("unexpected '%s' %v", bres, err)
+	}
+}
+
+func TestSetByKey(t *testing.T) {
+	json := `{"items":[{"id":1,"status":"new"},{"id":"2","status":"new"},` +
+		`{"id":3,"status":"new"}]}`
+	tests := []struct {
+		key    interface{}
+		field  string
+		value  interface{}
+		expect string
+	}{
+		{3, "status", "done", `{"items":[{"id":1,"status":"new"},` +
+			`{"id":"2","status":"new"},{"id":3,"status":"done"}]}`},
+		{3.0, "status", "done", `{"items":[{"id":1,"status":"new"},` +
+			`{"id":"2","status":"new"},{"id":3,"status":"done"}]}`},
+		{"2", "meta.seen", true, `{"items":[{"id":1,"status":"new"},` +
+			`{"id":"2","status":"new","meta":{"seen":true}},` +
+			`{"id":3,"status":"new"}]}`},
+	}
+	for i, tt := range tests {
+		res, err := SetByKey(json, "items", "id", tt.key, tt.field, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	// 2 is not equal to "2"
+	res, err := SetByKey(json, "items", "id", 2, "status", "done")
+	if !errors.Is(err, ErrPathNotFound) || res != json {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+	opts := &ByKeyOptions{AppendOnNoMatch: true}
+	res, err = SetByKeyOptions(json, "items", "id", 4, "status", "new", opts)
+	if err != nil || res != `{"items":[{"id":1,"status":"new"},`+
+		`{"id":"2","status":"new"},{"id":3,"status":"new"},`+
+		`{"id":4,"status":"new"}]}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = SetByKeyOptions(`{}`, "items", "id", 1, "status", "new", opts)
+	if err != nil || res != `{"items":[{"id":1,"status":"new"}]}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	opts.Compact = true
+	res, err = SetByKeyOptions(`{"items": [{"id": 1}]}`, "items", "id", 1,
+		"status", "new", opts)
+	if err != nil || res != `{"items":[{"id":1,"status":"new"}]}` {
+		t.Fatalf("unexpected %q %v", res, err)
+	}
+	res, err = SetByKey(`[{"id":1}]`, "", "id", 1, "s", "x")
+	if err != nil || res != `[{"id":1,"s":"x"}]` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = SetByKeyOptions(`[{"id":1}]`, "", "id", 1, "s", "x",
+		&ByKeyOptions{AppendOnNoMatch: true})
+	if err != nil || res != `[{"id":1,"s":"x"}]` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	_, err = SetByKey(`{"items":1}`, "items", "id", 1, "status", "new")
+	if !errors.Is(err, ErrNotAnArray) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAnArray, err)
+	}
+}
+
+func TestUpsertByKey(t *testing.T) {
+	type record struct {
+		ID   int    `json:"id"`
+		Name string `json:"name"`
+	}
+	doc := `{"list":[ {"id":1, "name":"a"} , {"id":2,"name":"b"} ]}`
+	res, err := UpsertByKey(doc, "list", "id", record{2, "c"})
+	if err != nil || res != `{"list":[ {"id":1, "name":"a"} , {"id":2,"name":"c"} ]}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = UpsertByKey(doc, "list", "id", record{3, "d"})
+	if err != nil || res != `{"list":[ {"id":1, "name":"a"} , {"id":2,"name":"b"} ,{"id":3,"name":"d"}]}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = UpsertByKey(`{}`, "list", "id", map[string]interface{}{"id": "x"})
+	if err != nil || res != `{"list":[{"id":"x"}]}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	_, err = UpsertByKey(doc, "list", "key", record{3, "d"})
+	if !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+	_, err = UpsertByKey(`{"list":{}}`, "list", "id", record{3, "d"})
+	if !errors.Is(err, ErrNotAnArray) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAnArray, err)
+	}
+	res, err = UpsertByKey(`[{"id":1,"name":"a"}]`, "", "id", record{1, "b"})
+	if err != nil || res != `[{"id":1,"name":"b"}]` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = UpsertByKey(`[{"id":1,"name":"a"}]`, "", "id", record{2, "b"})
+	if err != nil || res != `[{"id":1,"name":"a"},{"id":2,"name":"b"}]` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	json := `{"session":{"token":"abc","expired":true},"n":1}`
+	res, err := DeleteIf(json, "session.token", func(root gjson.Result) bool {
+		return root.Get("session.expired").Bool()
+	})
+	if err != nil || res != `{"session":{"expired":true},"n":1}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = DeleteIf(json, "session.token", func(root gjson.Result) bool {
+		return root.Get("n").Int() > 1
+	})
+	if err != nil || res != json {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	expired := func(v gjson.Result) bool { return v.Bool() }
+	res, err = DeleteIfAt(json, "session.token", "session.expired", expired)
+	if err != nil || res != `{"session":{"expired":true},"n":1}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = DeleteIfAt(json, "session.token", "session.missing", expired)
+	if err != nil || res != json {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	_, err = DeleteIfAt(json, "", "session.expired", expired)
+	if !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSetResult(t *testing.T) {
+	src := `{"big":12345678901234567890,"dec":0.1000000000000000055511151231257827,` +
+		`"n":1.50,"s":"ab","o":{"x": [1, 2]},"t":true,"huge":1e400}`
+	tests := []struct {
+		path          string
+		expect, exact string
+	}{
+		{"big", `12345678901234567000`, `12345678901234567890`},
+		{"dec", `0.1`, `0.1000000000000000055511151231257827`},
+		{"n", `1.5`, `1.50`},
+		{"huge", `1e400`, `1e400`},
+		{"s", `"ab"`, `"ab"`},
+		{"o", `{"x": [1, 2]}`, `{"x": [1, 2]}`},
+		{"t", `true`, `true`},
+		{"missing", `null`, `null`},
+	}
+	for i, tt := range tests {
+		value := gjson.Get(src, tt.path)
+		res, err := Set(`{}`, "v", value)
+		if err != nil || res != `{"v":`+tt.expect+`}` {
+			t.Fatalf("%d: expected '%v', got '%v' %v", i, tt.expect, res, err)
+		}
+		res, err = SetOptions(`{}`, "v", value,
+			&Options{PreserveNumberText: true})
+		if err != nil || res != `{"v":`+tt.exact+`}` {
+			t.Fatalf("%d: expected '%v', got '%v' %v", i, tt.exact, res, err)
+		}
+	}
+	res, err := SetOptions(`{}`, "v", gjson.Get(src, "n"),
+		&Options{FloatFormat: 'e', FloatPrecision: 2})
+	if err != nil || res != `{"v":1.50e+00}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	json := "{\n  \"a\" : [ 1, 2 ],\n  \"b\": \"x  y\\n\"\n}\n"
+	res, err := SetOptions(json, "c", map[string]int{"d": 1},
+		&Options{Compact: true})
+	if err != nil || res != `{"a":[1,2],"b":"x  y\n","c":{"d":1}}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	res, err = DeleteOptions(json, "a", &Options{Compact: true})
+	if err != nil || res != `{"b":"x  y\n"}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+	bres, err := SetBytesBuf(nil, []byte(json), "a", dtype{},
+		&Options{Compact: true})
+	if err != nil || string(bres) != `{"b":"x  y\n"}` {
+		t.Fatalf("unexpected '%s' %v", bres, err)
+	}
+	// pretty takes precedence
+	res, err = SetOptions(`{"a":1}`, "b", 2, &Options{Compact: true,
+		Pretty: true})
+	if err != nil || res != "{\n  \"a\": 1,\n  \"b\": 2\n}\n" {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestSetAndGetPrevious(t *testing.T) {
+	json := `{"a":{"b":[1, 2]},"c":"x"}`
+	tests := []struct {
+		path         string
+		value        interface{}
+		expect, prev string
+	}{
+		{"a.b", 3, `{"a":{"b":3},"c":"x"}`, `[1, 2]`},
+		{"c", "y", `{"a":{"b":[1, 2]},"c":"y"}`, `"x"`},
+		{"a.b.1", dtype{}, `{"a":{"b":[1]},"c":"x"}`, `2`},
+		{"d", 1, `{"a":{"b":[1, 2]},"c":"x","d":1}`, ``},
+		{"d", dtype{}, json, ``},
+	}
+	for i, tt := range tests {
+		res, prev, err := SetAndGetPrevious(json, tt.path, tt.value)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+		if prev.Raw != tt.prev || prev.Exists() != (tt.prev != "") {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.prev, prev.Raw)
+		}
+		if prev.Exists() && json[prev.Index:prev.Index+len(prev.Raw)] != prev.Raw {
+			t.Fatalf("%d: wrong index %d", i, prev.Index)
+		}
+	}
+	_, prev, err := SetAndGetPrevious(example, `friends.#(last="Murphy")#.last`,
+		"Johnson")
+	if err != nil || prev.Raw != `["Murphy","Murphy"]` {
+		t.Fatalf("unexpected '%v' %v", prev.Raw, err)
+	}
+	if _, _, err := SetAndGetPrevious(json, "", 1); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	json := `{"b":{"z":1.50,"a":[{"y":1,"x":2}]},"a":1,"c":2,"a":3}`
+	opts := &Options{SortKeys: true}
+	res, err := SetOptions(json, "b.m", "q", opts)
+	expect := `{"a":1,"a":3,"b":{"a":[{"x":2,"y":1}],"m":"q","z":1.50},"c":2}`
+	if err != nil || res != expect {
+		t.Fatalf("expected '%v', got '%v' %v", expect, res, err)
+	}
+	bres, err := SetBytesBuf(nil, []byte(json), "b.m", "q", opts)
+	if err != nil || string(bres) != expect {
+		t.Fatalf("expected '%v', got '%s' %v", expect, bres, err)
+	}
+	res, err = SetOptions(`{"b":1,"a":2}`, "c", 3,
+		&Options{SortKeys: true, Pretty: true})
+	if err != nil || res != "{\n  \"a\": 2,\n  \"b\": 1,\n  \"c\": 3\n}\n" {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestReorderElements(t *testing.T) {
+	json := `{"a":[ 1, {"b":2},"c" ,[3]]}`
+	tests := []struct {
+		fn     func() (string, error)
+		expect string
+	}{
+		{func() (string, error) { return SwapElements(json, "a", 0, 3) },
+			`{"a":[ [3], {"b":2},"c" ,1]}`},
+		{func() (string, error) { return SwapElements(json, "a", -1, 1) },
+			`{"a":[ 1, [3],"c" ,{"b":2}]}`},
+		{func() (string, error) { return SwapElements(json, "a", 2, 2) }, json},
+		{func() (string, error) { return MoveElement(json, "a", 0, 2) },
+			`{"a":[ {"b":2}, "c",1 ,[3]]}`},
+		{func() (string, error) { return MoveElement(json, "a", 3, 0) },
+			`{"a":[ [3], 1,{"b":2} ,"c"]}`},
+		{func() (string, error) { return MoveElement(json, "a", 1, -1) },
+			`{"a":[ 1, "c",[3] ,{"b":2}]}`},
+	}
+	for i, tt := range tests {
+		res, err := tt.fn()
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.expect, res)
+		}
+	}
+	errs := []struct {
+		fn  func() (string, error)
+		err error
+	}{
+		{func() (string, error) { return SwapElements(json, "a", 0, 4) },
+			ErrIndexOutOfRange},
+		{func() (string, error) { return MoveElement(json, "a", -5, 0) },
+			ErrIndexOutOfRange},
+		{func() (string, error) { return MoveElement(json, "x", 0, 1) },
+			ErrPathNotFound},
+		{func() (string, error) { return SwapElements(json, "a.1", 0, 1) },
+			ErrNotAnArray},
+	}
+	for i, tt := range errs {
+		if _, err := tt.fn(); !errors.Is(err, tt.err) {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.err, err)
+		}
+	}
+}
+
+func TestSetLiteral(t *testing.T) {
+	keys := []string{"a\nb", "c\td", "e.f", ":g", `h\i`, "1", "-1", "#", "k*",
+		`"q"`, "", "@m", "x|y", "\x01"}
+	for _, key := range keys {
+		res, err := SetLiteral(`{"z":{}}`, []string{"z", key}, 1)
+		if err != nil {
+			t.Fatalf("%q: %v", key, err)
+		}
+		obj := gjson.Parse(res).Get("z")
+		var found bool
+		obj.ForEach(func(k, v gjson.Result) bool {
+			found = k.String() == key && v.Raw == "1"
+			return true
+		})
+		if !found {
+			t.Fatalf("%q: unexpected '%v'", key, res)
+		}
+		res, err = SetLiteral(res, []string{"z", key}, 2)
+		if err != nil || strings.Count(res, "2") != 1 || strings.Contains(res, ":1") {
+			t.Fatalf("%q: unexpected '%v' %v", key, res, err)
+		}
+		res, err = DeleteLiteral(res, []string{"z", key})
+		if err != nil || res != `{"z":{}}` {
+			t.Fatalf("%q: unexpected '%v' %v", key, res, err)
+		}
+	}
+	if _, err := SetLiteral(`{}`, nil, 1); !errors.Is(err, ErrEmptyPath) {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSetIntKey(t *testing.T) {
+	tests := []struct {
+		json, parentPath string
+		key              int
+		expect           string
+	}{
+		{`{}`, "m", 5, `{"m":{"5":"x"}}`},
+		{`{"m":{"5":1}}`, "m", 5, `{"m":{"5":"x"}}`},
+		{`{"m":{}}`, "m", -1, `{"m":{"-1":"x"}}`},
+		{`{"m":{}}`, "m", -7, `{"m":{"-7":"x"}}`},
+		{`{"a":1}`, "", 0, `{"a":1,"0":"x"}`},
+		{`{"a":{"b":{}}}`, `a.b`, 12, `{"a":{"b":{"12":"x"}}}`},
+	}
+	for i, tt := range tests {
+		res, err := SetIntKey(tt.json, tt.parentPath, tt.key, "x")
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	json := `{}`
+	for _, key := range []int{3, 1, 2} {
+		var err error
+		if json, err = SetIntKey(json, "m", key, key*10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if json != `{"m":{"3":30,"1":10,"2":20}}` {
+		t.Fatalf("unexpected '%v'", json)
+	}
+	for _, json := range []string{`{"m":[1]}`, `[1]`} {
+		parentPath := "m"
+		if json[0] == '[' {
+			parentPath = ""
+		}
+		res, err := SetIntKey(json, parentPath, 0, "x")
+		if !errors.Is(err, ErrInvalidPath) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrInvalidPath, err)
+		}
+	}
+}
+
+func TestSetKeys(t *testing.T) {
+	tests := []struct {
+		json   string
+		keys   []string
+		value  interface{}
+		expect string
+	}{
+		{`{}`, []string{"a.b", "c*"}, 1, `{"a.b":{"c*":1}}`},
+		{`{}`, []string{"a", "2"}, 1, `{"a":[null,null,1]}`},
+		{`{}`, []string{"a", ":2"}, 1, `{"a":{"2":1}}`},
+		{`{}`, []string{"::a", ""}, 1, `{":a":{"":1}}`},
+		{`{"a":[1,2]}`, []string{"a", "-1"}, 3, `{"a":[1,2,3]}`},
+		{`{"a":[1,2]}`, []string{"a", "0"}, 3, `{"a":[3,2]}`},
+		{`{"a":{"0":1}}`, []string{"a", "0"}, 3, `{"a":{"0":3}}`},
+		{`{"a\\b":{"#":1}}`, []string{`a\b`, "#"}, 2, `{"a\\b":{"#":2}}`},
+		{`{"a|b":1,"@c":2}`, []string{"@c"}, dtype{}, `{"a|b":1}`},
+		{`{"a|b":1,"@c":2}`, []string{"a|b"}, dtype{}, `{"@c":2}`},
+		{`{"a":1}`, []string{"b", "c"}, dtype{}, `{"a":1}`},
+	}
+	for i, tt := range tests {
+		var res string
+		var err error
+		if _, ok := tt.value.(dtype); ok {
+			res, err = DeleteKeys(tt.json, tt.keys)
+		} else {
+			res, err = SetKeys(tt.json, tt.keys, tt.value)
+		}
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	res, err := SetRawKeys(`{"a":1}`, []string{"a"}, `[true]`)
+	if err != nil || res != `{"a":[true]}` {
+		t.Fatalf("expected '%v', got '%v' (%v)", `{"a":[true]}`, res, err)
+	}
+	if _, err := SetKeys(`{}`, nil, 1); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSetTopLevel(t *testing.T) {
+	jsons := []string{``, `{}`, ` { "a" : 1 , "b.c" : [1,{"}":"]"}] } `,
+		`{"a":"x\"y","a\"b":{"c":2}}`, `[1,2]`, `{"b.c":1,"b.c":2}`}
+	keys := []string{"a", "b.c", `a"b`, "*", "1", "-1", ":d", ""}
+	for _, json := range jsons {
+		for _, key := range keys {
+			for _, value := range []interface{}{"z", 3, dtype{}} {
+				res, err := SetTopLevel(json, key, value)
+				expect, experr := SetLiteral(json, []string{key}, value)
+				if res != expect || (err == nil) != (experr == nil) {
+					t.Fatalf("%q %q %v: expected '%v', got '%v' (%v)", json,
+						key, value, expect, res, err)
+				}
+			}
+		}
+	}
+	json := `{"app.token":"abc","name":"x","age":37}`
+	allocs := testing.AllocsPerRun(100, func() {
+		SetTopLevel(json, "name", "y")
+	})
+	if allocs != 1 {
+		t.Fatalf("expected 1 allocation, got %v", allocs)
+	}
+}
+
+func TestDeleteMatching(t *testing.T) {
+	tests := []struct {
+		json, path, pattern, expect string
+	}{
+		{`{"tmp_a":1,"b":2,"tmp_c":3}`, "", "tmp_*", `{"b":2}`},
+		{` { "a" : 1, "tmp" : 2 } `, "", "tmp*", ` { "a" : 1 } `},
+		{`{"a":1, "b":2, "c":3}`, "", "?", `{}`},
+		{`{"x":{"a1":1,"b":2,"a2":[3]},"a3":4}`, "x", "a?", `{"x":{"b":2},"a3":4}`},
+		{`{"x":{"a":1}}`, "x", "z*", `{"x":{"a":1}}`},
+		{`{"x":[1]}`, "x", "*", `{"x":[1]}`},
+		{`{"x":1}`, "y", "*", `{"x":1}`},
+		{`{"a.b":{"c":1,"d":2}}`, `a\.b`, "c", `{"a.b":{"d":2}}`},
+		{`{}`, "", "*", `{}`},
+	}
+	for i, tt := range tests {
+		res, err := DeleteMatching(tt.json, tt.path, tt.pattern)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	json := `{"user":{"name":"Tom","ssn":"123"},"cards":[{"num":"4111","exp":1},` +
+		`{"exp":2},{"num":"5500","kind":"debit"}],"token":null}`
+	res, err := Redact(json, []string{"user.ssn", "cards.#.num", "token",
+		"missing", "user.name.first", "cards.#(kind=\"debit\").exp"}, "***")
+	expect := `{"user":{"name":"Tom","ssn":"***"},"cards":[{"num":"***","exp":1},` +
+		`{"exp":2},{"num":"***","kind":"debit"}],"token":"***"}`
+	if err != nil || res != expect {
+		t.Fatalf("expected '%v', got '%v' (%v)", expect, res, err)
+	}
+	res, err = Redact(json, []string{"user.#.ssn"}, nil)
+	if err != nil || res != json {
+		t.Fatalf("expected '%v', got '%v' (%v)", json, res, err)
+	}
+	if _, err := Redact(json, []string{""}, "***"); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSetRawUTF8(t *testing.T) {
+	raws := []string{
+		`"héllo wörld"`,
+		`{"grüße":"こんにちは","emoji":"😀"}`,
+		"\n\t {\"日本\": [\"ü\", \"\\u00fc\"]} \n",
+		` "😀" `,
+	}
+	for _, raw := range raws {
+		for _, json := range []string{``, `{"a":1}`, `{"x":{"y":[1,2]}}`} {
+			for _, path := range []string{"k", "x.y.0", "x.ñ", "x.y.2"} {
+				res, err := SetRaw(json, path, raw)
+				if err != nil || !strings.Contains(res, raw) ||
+					!gjson.Valid(res) {
+					t.Fatalf("%q %q %q: unexpected '%v' (%v)", json, path,
+						raw, res, err)
+				}
+				// the path past the raw value still works
+				res, err = Set(res, path+".ß", "ok")
+				value := getPath(res, path+".ß")
+				if gjson.Get(raw, "@this").IsObject() {
+					if err != nil || value.String() != "ok" {
+						t.Fatalf("%q %q %q: unexpected '%v' (%v)", json,
+							path, raw, res, err)
+					}
+				}
+			}
+		}
+	}
+	res, err := SetRawBytes([]byte(`{"a":1}`), "a", []byte(" \"ü\" "))
+	if err != nil || string(res) != `{"a": "ü" }` {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+}
+
+func TestToggle(t *testing.T) {
+	tests := []struct {
+		json, path string
+		create     bool
+		expect     string
+	}{
+		{`{"on":true}`, "on", false, `{"on":false}`},
+		{`{"on": false }`, "on", false, `{"on": true }`},
+		{`{"flags":[true,false]}`, "flags.1", false, `{"flags":[true,true]}`},
+		{`{}`, "a.b", true, `{"a":{"b":true}}`},
+		{`{"on":false}`, "on", true, `{"on":true}`},
+	}
+	for i, tt := range tests {
+		res, err := ToggleOptions(tt.json, tt.path,
+			&BoolOptions{CreateMissing: tt.create})
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	for _, json := range []string{`{"on":"true"}`, `{"on":1}`, `{"on":null}`} {
+		res, err := Toggle(json, "on")
+		if !errors.Is(err, ErrNotABool) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrNotABool, err)
+		}
+	}
+	if _, err := Toggle(`{}`, "on"); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+	res, err := ToggleOptions(`{"on": true}`, "on",
+		&BoolOptions{Options: Options{Compact: true}})
+	if err != nil || res != `{"on":false}` {
+		t.Fatalf("unexpected '%v' %v", res, err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	json := `{"items":[1,2,{"a":3}],"config":{"x":{"y":1}},"n":5}`
+	tests := []struct {
+		path, expect string
+	}{
+		{"items", `{"items":[],"config":{"x":{"y":1}},"n":5}`},
+		{"config", `{"items":[1,2,{"a":3}],"config":{},"n":5}`},
+		{"config.x", `{"items":[1,2,{"a":3}],"config":{"x":{}},"n":5}`},
+		{"items.2", `{"items":[1,2,{}],"config":{"x":{"y":1}},"n":5}`},
+	}
+	for i, tt := range tests {
+		res, err := Clear(json, tt.path)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res, err)
+		}
+	}
+	if _, err := Clear(json, "n"); !errors.Is(err, ErrNotAContainer) {
+		t.Fatalf("expected '%v', got '%v'", ErrNotAContainer, err)
+	}
+	if _, err := Clear(json, "missing"); !errors.Is(err, ErrPathNotFound) {
+		t.Fatalf("expected '%v', got '%v'", ErrPathNotFound, err)
+	}
+}
+
+func TestAppendStringSuffix(t *testing.T) {
+	tests := []struct {
+		json, path, suffix, expect string
+	}{
+		{`{"log":"a"}`, "log", "b", `{"log":"ab"}`},
+		{`{"log":"café "}`, "log", `"q"`, `{"log":"café \"q\""}`},
+		{`{"log":""}`, "log", "\n", `{"log":"\n"}`},
+		{`{"a":["x"]}`, "a.0", "y", `{"a":["xy"]}`},
+		{`{}`, "a.b", "new", `{"a":{"b":"new"}}`},
+		{`{"log":"a"}`, "log", "", `{"log":"a"}`},
+	}
+	for i, tt := range tests {
+		res, err := AppendString(tt.json, tt.path, tt.suffix)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	for _, json := range []string{`{"log":1}`, `{"log":null}`, `{"log":[]}`} {
+		res, err := AppendString(json, "log", "x")
+		if !errors.Is(err, ErrNotAString) || res != json {
+			t.Fatalf("expected '%v', got '%v'", ErrNotAString, err)
+		}
+	}
+}
+
+func TestSetBytesOptionsMany(t *testing.T) {
+	json := []byte(`{"a":1,"b":{"c":"x"}}`)
+	paths := []string{"a", "b.c", "d.-1", "a"}
+	values := []interface{}{"one", nil, true, 2}
+	expect := `{"a":2,"b":{"c":null},"d":[true]}`
+	res, err := SetBytesOptionsMany(json, paths, values, nil)
+	if err != nil || string(res) != expect {
+		t.Fatalf("expected '%v', got '%s' (%v)", expect, res, err)
+	}
+	if string(json) != `{"a":1,"b":{"c":"x"}}` {
+		t.Fatalf("unexpected '%s'", json)
+	}
+	buf := make([]byte, len(json), 64)
+	copy(buf, json)
+	res, err = SetBytesOptionsMany(buf, paths, values,
+		&Options{ReplaceInPlace: true})
+	if err != nil || string(res) != expect || &res[0] != &buf[0] {
+		t.Fatalf("expected '%v', got '%s' (%v)", expect, res, err)
+	}
+	res, err = SetBytesOptionsMany(json, paths, values, &Options{Pretty: true})
+	if err != nil || string(res) != string(pretty.Pretty([]byte(expect))) {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+	_, err = SetBytesOptionsMany(json, paths, values[:1], nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	res, err = SetBytesOptionsMany(json, []string{"a", ""},
+		[]interface{}{2, 3}, nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "path 1:") ||
+		string(res) != string(json) {
+		t.Fatalf("unexpected '%s' (%v)", res, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	double := func(value gjson.Result) interface{} {
+		if !value.Exists() {
+			return 1
+		}
+		return value.Int() * 2
+	}
+	tests := []struct {
+		json, path, expect string
+	}{
+		{`{"n":21}`, "n", `{"n":42}`},
+		{`{"a":[1,2,3]}`, "a.1", `{"a":[1,4,3]}`},
+		{`{"a":[{"k":"x","n":3}]}`, `a.#(k="x").n`, `{"a":[{"k":"x","n":6}]}`},
+		{`{}`, "a.b", `{"a":{"b":1}}`},
+		{`{"a":[5]}`, "a.-1", `{"a":[5,1]}`},
+		{`{"a.b":2}`, `a\.b`, `{"a.b":4}`},
+	}
+	for i, tt := range tests {
+		res, err := Update(tt.json, tt.path, double)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	res, err := Update(`{"name":"tom"}`, "name",
+		func(value gjson.Result) interface{} {
+			return strings.ToUpper(value.String()) + ` "jr"`
+		})
+	if err != nil || res != `{"name":"TOM \"jr\""}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	if _, err := Update(`{}`, "", double); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestSetRoot(t *testing.T) {
+	tests := []struct {
+		json   string
+		value  interface{}
+		expect string
+	}{
+		{`{"a":1}`, map[string]int{"b": 2}, `{"b":2}`},
+		{`{"a":1}`, []int{1, 2}, `[1,2]`},
+		{`[1,2]`, "x\"y", `"x\"y"`},
+		{``, 3.5, `3.5`},
+		{`"x"`, nil, `null`},
+		{`{}`, true, `true`},
+	}
+	for i, tt := range tests {
+		res, err := SetRoot(tt.json, tt.value)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	res, err := SetRawRoot(`{"a":1}`, " [true] ")
+	if err != nil || res != ` [true] ` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	for _, value := range []string{``, `[true`, `{"a":}`, `1 2`} {
+		res, err := SetRawRoot(`{"a":1}`, value)
+		if !errors.Is(err, ErrInvalidJSON) || res != `{"a":1}` {
+			t.Fatalf("expected '%v', got '%v' (%v)", ErrInvalidJSON, res, err)
+		}
+	}
+	if _, err := Delete(`{"a":1}`, ""); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestEscapedWildcardKeys(t *testing.T) {
+	json := `{"counts":{"#total":1,"a*":2,"b?":3,"#":4,"*":5,"ab":6}}`
+	tests := []struct {
+		path, set, del string
+	}{
+		{`counts.\#total`,
+			`{"counts":{"#total":9,"a*":2,"b?":3,"#":4,"*":5,"ab":6}}`,
+			`{"counts":{"a*":2,"b?":3,"#":4,"*":5,"ab":6}}`},
+		{`counts.a\*`,
+			`{"counts":{"#total":1,"a*":9,"b?":3,"#":4,"*":5,"ab":6}}`,
+			`{"counts":{"#total":1,"b?":3,"#":4,"*":5,"ab":6}}`},
+		{`counts.b\?`,
+			`{"counts":{"#total":1,"a*":2,"b?":9,"#":4,"*":5,"ab":6}}`,
+			`{"counts":{"#total":1,"a*":2,"#":4,"*":5,"ab":6}}`},
+		{`counts.\#`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"#":9,"*":5,"ab":6}}`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"*":5,"ab":6}}`},
+		{`counts.\*`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"#":4,"*":9,"ab":6}}`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"#":4,"ab":6}}`},
+		{`counts.\#new`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"#":4,"*":5,"ab":6,"#new":9}}`,
+			json},
+		{`new.\?.\*`,
+			`{"counts":{"#total":1,"a*":2,"b?":3,"#":4,"*":5,"ab":6},` +
+				`"new":{"?":{"*":9}}}`,
+			json},
+	}
+	for i, tt := range tests {
+		res, err := Set(json, tt.path, 9)
+		if err != nil || res != tt.set {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.set, res, err)
+		}
+		res, err = Delete(json, tt.path)
+		if err != nil || res != tt.del {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.del, res, err)
+		}
+		p, err := CompilePath(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res, _ := p.Set(json, 9); res != tt.set {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.set, res)
+		}
+		if res, _ := p.Delete(json); res != tt.del {
+			t.Fatalf("%d: expected '%v', got '%v'", i, tt.del, res)
+		}
+	}
+}
+
+func TestMaxInputBytes(t *testing.T) {
+	json := `{"a":1,"b":2}`
+	opts := &Options{MaxInputBytes: len(json)}
+	res, err := SetOptions(json, "a", 3, opts)
+	if err != nil || res != `{"a":3,"b":2}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	opts.MaxInputBytes = len(json) - 1
+	bres, err := SetBytesOptions([]byte(json), "a", 3, opts)
+	var perr *PathError
+	if !errors.As(err, &perr) || perr.Path != "a" ||
+		!errors.Is(err, ErrInputTooLarge) || string(bres) != json {
+		t.Fatalf("expected '%v', got '%v'", ErrInputTooLarge, err)
+	}
+	bres, err = SetRawBytesOptions([]byte(json), "c", []byte(`[]`), opts)
+	if !errors.Is(err, ErrInputTooLarge) || string(bres) != json {
+		t.Fatalf("expected '%v', got '%v'", ErrInputTooLarge, err)
+	}
+	if _, err := DeleteOptions(json, "a", opts); !errors.Is(err,
+		ErrInputTooLarge) {
+		t.Fatalf("expected '%v', got '%v'", ErrInputTooLarge, err)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	tests := []struct {
+		json   string
+		paths  []string
+		expect string
+	}{
+		{`{"a":1,"b":2,"c":3,"d":4}`, []string{"b", "c"}, `{"a":1,"d":4}`},
+		{`{"a":1,"b":2,"c":3,"d":4}`, []string{"d", "a"}, `{"b":2,"c":3}`},
+		{`{"a":1,"b":2,"c":3}`, []string{"a", "b", "c"}, `{}`},
+		{`{ "a" : 1 , "b" : 2 }`, []string{"a"}, `{ "b" : 2 }`},
+		{`[0,1,2,3,4]`, []string{"0", "2", "-1"}, `[1,3]`},
+		{`[0,1,2]`, []string{"1", "1"}, `[0,2]`},
+		{`{"a":{"b":1,"c":2},"d":3}`, []string{"a.b", "a", "d"}, `{}`},
+		{`{"a":{"b":1,"c":2},"d":[1,{"e":2}]}`, []string{"a.c", "d.1.e",
+			"x.y"}, `{"a":{"b":1},"d":[1,{}]}`},
+		{`{"a":1}`, nil, `{"a":1}`},
+		{`{"a":1,"b":[1,2`, []string{"b"}, `{"a":1`},
+		{`{"a":1, "b":2 `, []string{"b"}, `{"a":1 `},
+		{`{"a":1, "b":2 `, []string{"a", "b"}, `{ `},
+		{`[1,2`, []string{"0"}, `[2`},
+		{`{"a":1,"b":2}x`, []string{"a", "b"}, `{}x`},
+	}
+	for i, tt := range tests {
+		res, err := DeleteMany(tt.json, tt.paths)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+	json := `{"a":1}`
+	res, err := DeleteMany(json, []string{"a", ""})
+	if !errors.Is(err, ErrEmptyPath) || err.Error() != "path 1: "+
+		ErrEmptyPath.Error() || res != json {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+}
+
+func TestSetAfter(t *testing.T) {
+	tests := []struct {
+		json, path, after string
+		expect            string
+	}{
+		{`{"a":1,"b":2,"c":3}`, "x", "a", `{"a":1,"x":9,"b":2,"c":3}`},
+		{`{"a":1,"b":2,"c":3}`, "x", "c", `{"a":1,"b":2,"c":3,"x":9}`},
+		{`{"a":1,"b":2}`, "x", "z", `{"a":1,"b":2,"x":9}`},
+		{`{"a":1,"b":2}`, "b", "a", `{"a":1,"b":9}`},
+		{`{"o":{"a":[1,2], "b":{}}}`, "o.x.y", "a",
+			`{"o":{"a":[1,2],"x":{"y":9}, "b":{}}}`},
+		{`{"o":{"a":1}}`, "p.x", "o", `{"o":{"a":1},"p":{"x":9}}`},
+		{"\n{\"a\":1,\n\"b\":2}\n", "x", "a", "\n{\"a\":1,\"x\":9,\n\"b\":2}\n"},
+		{`{}`, "x", "a", `{"x":9}`},
+		{`[1]`, "0", "a", `[9]`},
+	}
+	for i, tt := range tests {
+		res, err := SetAfter(tt.json, tt.path, tt.after, 9)
+		if err != nil || res != tt.expect {
+			t.Fatalf("%d: expected '%v', got '%v' (%v)", i, tt.expect, res,
+				err)
+		}
+	}
+}
+
+func TestEnsurePath(t *testing.T) {
+	json := `{}`
+	for i, path := range []string{"server.port", "server.tls.enabled",
+		"server.port"} {
+		res, created, err := EnsurePath(json, path, 8080)
+		if err != nil || created != (i < 2) {
+			t.Fatalf("%d: unexpected %v (%v)", i, created, err)
+		}
+		json = res
+	}
+	if json != `{"server":{"port":8080,"tls":{"enabled":8080}}}` {
+		t.Fatalf("unexpected '%s'", json)
+	}
+	res, created, err := EnsurePath(`{"a":null}`, "a", 1)
+	if err != nil || created || res != `{"a":null}` {
+		t.Fatalf("unexpected '%s' %v (%v)", res, created, err)
+	}
+}
+
+func TestSetOutcome(t *testing.T) {
+	tests := []struct {
+		json, path string
+		expect     string
+		created    bool
+	}{
+		{`{"a":1}`, "a", `{"a":9}`, false},
+		{`{"a":null}`, "a", `{"a":9}`, false},
+		{`{"a":1}`, "b", `{"a":1,"b":9}`, true},
+		{`{"a":1}`, "b.c.0", `{"a":1,"b":{"c":[9]}}`, true},
+		{`{"a":[1]}`, "a.-1", `{"a":[1,9]}`, true},
+		{`{"a":[1]}`, "a.0", `{"a":[9]}`, false},
+		{`{"a":[{"b":1}]}`, "a.#.b", `{"a":[{"b":9}]}`, false},
+		{``, "a", `{"a":9}`, true},
+	}
+	for i, tt := range tests {
+		res, created, err := SetOutcome(tt.json, tt.path, 9)
+		if err != nil || res != tt.expect || created != tt.created {
+			t.Fatalf("%d: expected '%v' %v, got '%v' %v (%v)", i, tt.expect,
+				tt.created, res, created, err)
+		}
+	}
+	if _, _, err := SetOutcome(`{}`, "", 1); err != ErrEmptyPath {
+		t.Fatalf("expected '%v', got '%v'", ErrEmptyPath, err)
+	}
+}
+
+func TestDeleteAndGet(t *testing.T) {
+	tests := []struct {
+		json, path, expect, deleted string
+	}{
+		{`{"a":1,"b":{"c":[1, 2]}}`, "b", `{"a":1}`, `{"c":[1, 2]}`},
+		{`{"a" : "x:y" , "b":2}`, "a", `{ "b":2}`, `"x:y"`},
+		{`{"a":["x","y","z"]}`, "a.1", `{"a":["x","z"]}`, `"y"`},
+		{`{"a":["x","y","z"]}`, "a.-1", `{"a":["x","y"]}`, `"z"`},
+		{`["x"]`, "0", `[]`, `"x"`},
+		{`{"a":1}`, "b", `{"a":1}`, ``},
+	}
+	for i, tt := range tests {
+		res, deleted, err := DeleteAndGet(tt.json, tt.path)
+		if err != nil || res != tt.expect || deleted.Raw != tt.deleted ||
+			deleted.Exists() != (tt.deleted != "") {
+			t.Fatalf("%d: expected '%v' '%v', got '%v' '%v' (%v)", i,
+				tt.expect, tt.deleted, res, deleted.Raw, err)
+		}
+	}
+	json := `{"a":{"b":true}}`
+	_, deleted, _ := DeleteAndGet(json, "a.b")
+	if json[deleted.Index:deleted.Index+len(deleted.Raw)] != "true" {
+		t.Fatalf("unexpected index %d", deleted.Index)
+	}
+}
+
+func TestPopShift(t *testing.T) {
+	json := `{"queue":[{"id":1}, {"id":2}, {"id":3}]}`
+	res, popped, err := Pop(json, "queue")
+	if err != nil || res != `{"queue":[{"id":1}, {"id":2}]}` ||
+		popped.Raw != `{"id":3}` {
+		t.Fatalf("unexpected '%v' '%v' (%v)", res, popped.Raw, err)
+	}
+	res, shifted, err := Shift(res, "queue")
+	if err != nil || res != `{"queue":[ {"id":2}]}` ||
+		shifted.Raw != `{"id":1}` {
+		t.Fatalf("unexpected '%v' '%v' (%v)", res, shifted.Raw, err)
+	}
+	res, _, err = Pop(res, "queue")
+	if err != nil || res != `{"queue":[ ]}` {
+		t.Fatalf("unexpected '%v' (%v)", res, err)
+	}
+	res, popped, err = Pop(` [1,2] `, "")
+	if err != nil || res != ` [1] ` || popped.Int() != 2 {
+		t.Fatalf("unexpected '%v' '%v' (%v)", res, popped.Raw, err)
+	}
+	for _, tt := range []struct {
+		json string
+		err  error
+	}{
+		{`{"queue":[]}`, ErrIndexOutOfRange},
+		{`{"queue":[ ]}`, ErrIndexOutOfRange},
+		{`{"queue":{}}`, ErrNotAnArray},
+		{`{}`, ErrPathNotFound},
+		{`{"queue":[`, ErrInvalidJSON},
+		{`{"queue":[1,2`, ErrInvalidJSON},
+		{`[`, ErrInvalidJSON},
+	} {
+		path := "queue"
+		if tt.json[0] == '[' {
+			path = ""
+		}
+		for _, fn := range []func(string, string) (string, gjson.Result,
+			error){Pop, Shift} {
+			res, popped, err := fn(tt.json, path)
+			if !errors.Is(err, tt.err) || res != tt.json || popped.Exists() {
+				t.Fatalf("%v: expected '%v', got '%v'", tt.json, tt.err, err)
+			}
+		}
+	}
+}
+
+func TestBOM(t *testing.T) {
+	const bom = "\xef\xbb\xbf"
+	tests := []struct {
+		json, path string
+		value      interface{}
+		expect     string
+	}{
+		{`{"a":1}`, "a", 2, `{"a":2}`},
+		{`{"a":1}`, "b", 2, `{"a":1,"b":2}`},
+		{`{"a":1}`, "a", dtype{}, `{}`},
+		{`{"a":{"b":[1]}}`, "a.b.-1", 2, `{"a":{"b":[1,2]}}`},
+		{`[1,2]`, "-1", 3, `[1,2,3]`},
+		{`[1,2]`, "0", dtype{}, `[2]`},
+		{`[{"a":1},{"a":2}]`, "#.a", 3, `[{"a":3},{"a":3}]`},
+		{``, "a", 1, `{"a":1}`},
+	}
+	for i, tt := range tests {
+		for _, strip := range []bool{false, true} {
+			res, err := SetOptions(bom+tt.json, tt.path, tt.value,
+				&Options{StripBOM: strip})
+			expect := tt.expect
+			if !strip {
+				expect = bom + expect
+			}
+			if err != nil || res != expect {
+				t.Fatalf("%d: expected %q, got %q (%v)", i, expect, res, err)
+			}
+		}
+	}
+	res, err := Delete(bom+`{"a":1}`, "b")
+	if err != nil || res != bom+`{"a":1}` {
+		t.Fatalf("unexpected %q (%v)", res, err)
+	}
+}
@@ -0,0 +1,82 @@
+package sjson
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unsafe"
+)
+
+// SetWriter sets a json value for the specified path and writes the
+// resulting json to dst.
+// The unchanged prefix and suffix of the input are written directly to dst
+// along with the modified region, so the full result is never built in
+// memory. This is preferred over SetBytesOptions when the result is going
+// to a file or a network connection.
+//
+// The Optimistic option is honored, ReplaceInPlace is ignored. The options
+// that format the whole result, such as Pretty, Compact, SortKeys, and
+// NormalizeNumbers, are applied to the full result, which is then built in
+// memory before it's written.
+func SetWriter(dst io.Writer, json []byte, path string, value interface{},
+	opts *Options) error {
+	raw, stringify, del, err := valueRaw(value, opts)
+	if err != nil {
+		return &PathError{Path: path, Offset: -1, Err: err}
+	}
+	jstr := *(*string)(unsafe.Pointer(&json))
+	e, err := setEdit(jstr, path, raw, stringify, del, opts)
+	if err == errNoChange {
+		_, err = dst.Write(formatResult(json, opts))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if reformats(opts) {
+		res := e.append(make([]byte, 0, e.size(jstr)), jstr)
+		_, err = dst.Write(formatResult(res, opts))
+		return err
+	}
+	return e.write(dst, json)
+}
+
+// write writes the new document to w.
+func (e *edit) write(w io.Writer, json []byte) error {
+	if _, err := w.Write(json[e.lead:e.start]); err != nil {
+		return err
+	}
+	if _, err := w.Write(e.appendMid(nil)); err != nil {
+		return err
+	}
+	_, err := w.Write(json[e.end:])
+	return err
+}
+
+// SetReader reads the json document from r and sets a json value for the
+// specified path.
+func SetReader(r io.Reader, path string, value interface{},
+	opts *Options) ([]byte, error) {
+	return SetManyReader(r, []PathValue{{Path: path, Value: value}}, opts)
+}
+
+// SetManyReader reads the json document from r and sets many values, in
+// order, as if SetBytesOptions was called for each pair. The document is
+// read into one buffer that's edited in place when the new values fit in
+// its spare capacity, so that about one copy of the document is kept in
+// memory no matter how many pairs are set. An error identifying the index
+// of the pair is returned when a value cannot be set.
+func SetManyReader(r io.Reader, pairs []PathValue, opts *Options) ([]byte,
+	error) {
+	json, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	ed := &Editor{buf: json, opts: opts}
+	for i, pair := range pairs {
+		if err := ed.Set(pair.Path, pair.Value); err != nil {
+			return nil, fmt.Errorf("pair %d: %w", i, err)
+		}
+	}
+	return formatResult(ed.Bytes(), opts), nil
+}
@@ -0,0 +1,94 @@
+package sjson
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSetWriter(t *testing.T) {
+	tests := []struct {
+		json, path string
+		value      interface{}
+	}{
+		{`{"a":1,"b":2}`, "a", "hello"},
+		{`{"a":1,"b":2}`, "c.d", 10.5},
+		{` [1,2] `, "-1", true},
+		{`[1,2]`, "4", nil},
+		{``, "a.b.c", map[string]int{"d": 1}},
+		{`{"a":1,"b":2}`, "b", dtype{}},
+		{`{"a":1,"b":2}`, "c", dtype{}},
+		{example, `friends.#(last="Murphy")#.last`, "Johnson"},
+		{`{"b":1.50E+03, "a":1}`, "c", 1},
+	}
+	for i, tt := range tests {
+		for _, opts := range []*Options{{}, {Optimistic: true},
+			{Pretty: true, Indent: " "}, {Compact: true}, {SortKeys: true},
+			{NormalizeNumbers: true}} {
+			expect, err := SetBytesOptions([]byte(tt.json), tt.path, tt.value, opts)
+			if err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			var w bytes.Buffer
+			if err := SetWriter(&w, []byte(tt.json), tt.path, tt.value, opts); err != nil {
+				t.Fatalf("%d: %v", i, err)
+			}
+			if w.String() != string(expect) {
+				t.Fatalf("%d: expected '%s', got '%s'", i, expect, w.String())
+			}
+		}
+	}
+	var w bytes.Buffer
+	if err := SetWriter(&w, []byte(`{"a":1}`), "", 1, nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if w.Len() != 0 {
+		t.Fatal("expected nothing to be written")
+	}
+	errWrite := errors.New("write failed")
+	err := SetWriter(errWriter{errWrite}, []byte(`{"a":1}`), "a", 2, nil)
+	if err != errWrite {
+		t.Fatalf("expected '%v', got '%v'", errWrite, err)
+	}
+}
+
+type errWriter struct{ err error }
+
+func (w errWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestSetReader(t *testing.T) {
+	json := `{"a":1,"b":{"c":[1,2]}}`
+	res, err := SetReader(strings.NewReader(json), "b.c.-1", 3, nil)
+	if err != nil || string(res) != `{"a":1,"b":{"c":[1,2,3]}}` {
+		t.Fatalf("unexpected '%s' %v", res, err)
+	}
+	pairs := []PathValue{
+		{"a", "hello"},
+		{"b.c.0", dtype{}},
+		{"d.e", true},
+	}
+	expect, _ := SetMany(json, pairs)
+	res, err = SetManyReader(strings.NewReader(json), pairs, nil)
+	if err != nil || string(res) != expect {
+		t.Fatalf("expected '%s', got '%s' %v", expect, res, err)
+	}
+	res, err = SetManyReader(strings.NewReader(json), pairs,
+		&Options{Pretty: true})
+	if err != nil || !bytes.Contains(res, []byte("\n")) {
+		t.Fatalf("unexpected '%s' %v", res, err)
+	}
+	_, err = SetManyReader(strings.NewReader(json), []PathValue{{"a", 1},
+		{"", 2}}, nil)
+	if !errors.Is(err, ErrEmptyPath) || !strings.HasPrefix(err.Error(), "pair 1: ") {
+		t.Fatalf("unexpected '%v'", err)
+	}
+	errRead := errors.New("read failed")
+	if _, err := SetReader(errReader{errRead}, "a", 1, nil); err != errRead {
+		t.Fatalf("expected '%v', got '%v'", errRead, err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }